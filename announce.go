@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// announceCooldown is how long to wait before announcing the same queue again.
+const announceCooldown = 15 * time.Minute
+
+// joinEmoji is the reaction players use to join the queue from an announcement.
+const joinEmoji = "➕"
+
+// announcement is a message inviting players to join a queue.
+type announcement struct {
+	queueType int
+	posted    time.Time
+}
+
+var announcements = make(map[string]*announcement)
+var lastAnnounced = make(map[int]time.Time)
+var announceMutex sync.Mutex
+
+// parseAnnounceNeeds reads how many more players each queue needs before it is announced, such as "private=2,quad=1".
+// sizes are how many players each queue needs for a match, by queue type.
+func parseAnnounceNeeds(spec string, sizes map[int]int) (map[int]int, error) {
+	needs := make(map[int]int)
+	for _, part := range strings.Split(spec, ",") {
+		if part == "" {
+			continue
+		}
+
+		fields := strings.SplitN(part, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q must be queue=players", part)
+		}
+		queueType, ok := parseQueueType(fields[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown queue %q", fields[0])
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n >= sizes[queueType] {
+			return nil, fmt.Errorf("invalid number of players %q for %s", fields[1], fields[0])
+		}
+		needs[queueType] = n
+	}
+	return needs, nil
+}
+
+// queueGrew is called when players join a queue without a match being found.
+func queueGrew(s *discordgo.Session, queueType int, before int, after int) {
+	required := searchQueues[queueType].RequiredPlayers
+	if before < required-1 && after >= required-1 {
+		queueAlmostFull(s, queueType)
+	}
+	if needs, ok := currentConfig().announceNeeds[queueType]; ok && before < required-needs && after >= required-needs {
+		announceQueue(s, queueType, required-after)
+	}
+}
+
+// announceQueue posts that a queue needs more players in the announcement channels, with a reaction to join.
+func announceQueue(s *discordgo.Session, queueType int, needs int) {
+	channels := currentConfig().announceChannels
+	if len(channels) == 0 {
+		return
+	}
+
+	announceMutex.Lock()
+	defer announceMutex.Unlock()
+
+	if time.Since(lastAnnounced[queueType]) < announceCooldown {
+		return
+	}
+	lastAnnounced[queueType] = time.Now()
+
+	// Old announcements are no longer worth answering
+	for id, a := range announcements {
+		if time.Since(a.posted) > announceCooldown {
+			delete(announcements, id)
+		}
+	}
+
+	msg := fmt.Sprintf("A %s battle needs %d more! React with %s to join the queue.", queueName(queueType), needs, joinEmoji)
+	for _, channelID := range channels {
+		message, err := s.ChannelMessageSend(channelID, msg)
+		if err != nil {
+			continue
+		}
+		s.MessageReactionAdd(channelID, message.ID, joinEmoji)
+		announcements[message.ID] = &announcement{queueType: queueType, posted: time.Now()}
+	}
+}
+
+// joinFromAnnouncement adds a player to the queue of the announcement they reacted to.
+// Returns true if the reaction was for an announcement.
+func joinFromAnnouncement(s *discordgo.Session, r *discordgo.MessageReactionAdd) bool {
+	if r.Emoji.Name != joinEmoji {
+		return false
+	}
+
+	announceMutex.Lock()
+	a, ok := announcements[r.MessageID]
+	announceMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	addToQueue(s, r.UserID, pickup.SearchChannelID, searchQueues[a.queueType], a.queueType, nil)
+	return true
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// apiServer serves the JSON admin API over the same queues and rooms the bot uses.
+type apiServer struct {
+	session *discordgo.Session
+	token   string
+}
+
+type entryJSON struct {
+	PlayerID string    `json:"playerId"`
+	Name     string    `json:"name"`
+	Modes    []string  `json:"modes,omitempty"`
+	Region   string    `json:"region,omitempty"`
+	Group    int       `json:"group,omitempty"`
+	Joined   time.Time `json:"joined"`
+}
+
+type queueJSON struct {
+	Name            string      `json:"name"`
+	RequiredPlayers int         `json:"requiredPlayers"`
+	Entries         []entryJSON `json:"entries"`
+}
+
+type scrimTeamJSON struct {
+	Team    string   `json:"team"`
+	Tag     string   `json:"tag"`
+	Players []string `json:"players"`
+}
+
+type roomJSON struct {
+	ID          int       `json:"id"`
+	Queue       string    `json:"queue"`
+	Mode        string    `json:"mode,omitempty"`
+	Created     time.Time `json:"created"`
+	TextChannel string    `json:"textChannel"`
+	Players     []string  `json:"players"`
+}
+
+type playerJSON struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	FriendCode string      `json:"friendCode"`
+	Region     string      `json:"region,omitempty"`
+	State      string      `json:"state"`
+	Queues     []string    `json:"queues"`
+	Room       int         `json:"room,omitempty"`
+	Team       string      `json:"team,omitempty"`
+	Ban        *pickup.Ban `json:"ban,omitempty"`
+}
+
+// startHTTP serves the dashboard, the metrics and, if a token is given, the admin API on an address such as ":8080".
+// Every API request must send the token as a bearer token.
+func startHTTP(s *discordgo.Session, addr string, token string) {
+	mux := http.NewServeMux()
+	startDashboard(s, mux)
+	serveMetrics(mux)
+	if token != "" {
+		api := &apiServer{session: s, token: token}
+		mux.HandleFunc("/api/queues", api.authorize(api.handleQueues))
+		mux.HandleFunc("/api/rooms", api.authorize(api.handleRooms))
+		mux.HandleFunc("/api/rooms/", api.authorize(api.handleRoom))
+		mux.HandleFunc("/api/players/", api.authorize(api.handlePlayer))
+	}
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Error occurred while serving HTTP", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// authorize rejects requests without the API token.
+func (api *apiServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleQueues lists the players in every queue.
+// GET /api/queues
+func (api *apiServer) handleQueues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+
+	guildID := pickup.GetGuildID(api.session)
+	queues := make(map[string]interface{})
+	for queueType, q := range searchQueues {
+		queue := queueJSON{Name: queueName(queueType), RequiredPlayers: q.RequiredPlayers, Entries: []entryJSON{}}
+		for _, e := range q.EntrySnapshot() {
+			queue.Entries = append(queue.Entries, entryJSON{
+				PlayerID: e.Player.ID,
+				Name:     pickup.DisplayName(api.session, guildID, e.Player.ID),
+				Modes:    e.Modes,
+				Region:   e.Region,
+				Group:    e.Group,
+				Joined:   e.Joined,
+			})
+		}
+		queues[queue.Name] = queue
+	}
+
+	teams := []scrimTeamJSON{}
+	for _, team := range scrimQueue.Snapshot() {
+		teams = append(teams, scrimTeamJSON{Team: team.Team.Name, Tag: team.Team.Tag, Players: playerIDs(team.Players)})
+	}
+	queues[queueName(pickup.Scrim)] = teams
+
+	writeJSON(w, http.StatusOK, queues)
+}
+
+// handleRooms lists the active rooms.
+// GET /api/rooms
+func (api *apiServer) handleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+
+	rooms := []roomJSON{}
+	for _, room := range activeRooms() {
+		rooms = append(rooms, newRoomJSON(room))
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// handleRoom shows or closes a room.
+// GET /api/rooms/{id}
+// POST /api/rooms/{id}/close
+func (api *apiServer) handleRoom(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/rooms/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	room := findRoom(id)
+	if err != nil || room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, newRoomJSON(room))
+	case len(parts) == 2 && parts[1] == "close" && r.Method == http.MethodPost:
+		guildID := pickup.GetGuildID(api.session)
+		closeRoom(api.session, guildID, room)
+		eventLog.Log(pickup.EventModerator, room.ID, "HTTP API used close")
+		writeJSON(w, http.StatusOK, map[string]string{"status": "closed"})
+	default:
+		writeError(w, http.StatusNotFound, "unknown room action")
+	}
+}
+
+// handlePlayer looks up a player or kicks them from the queues.
+// GET /api/players/{id}
+// POST /api/players/{id}/kick
+func (api *apiServer) handlePlayer(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/players/"), "/")
+	id := parts[0]
+	if !playerStore.PlayerExists(id) {
+		writeError(w, http.StatusNotFound, "player not found")
+		return
+	}
+	guildID := pickup.GetGuildID(api.session)
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, api.newPlayerJSON(guildID, id))
+	case len(parts) == 2 && parts[1] == "kick" && r.Method == http.MethodPost:
+		p, ok := registry.Get(id)
+		if !ok || registry.State(id) != pickup.StateSearching {
+			writeError(w, http.StatusConflict, "player is not in a queue")
+			return
+		}
+		removeFromQueues(api.session, guildID, p, "kicked through the HTTP API")
+		eventLog.Log(pickup.EventModerator, 0, "HTTP API used kick", id)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "kicked"})
+	default:
+		writeError(w, http.StatusNotFound, "unknown player action")
+	}
+}
+
+// newPlayerJSON describes a registered player. Players the bot has not seen since it started are read from the store.
+func (api *apiServer) newPlayerJSON(guildID string, id string) playerJSON {
+	p, ok := registry.Get(id)
+	if !ok {
+		p = playerStore.GetPlayer(id)
+	}
+	player := playerJSON{
+		ID:         id,
+		Name:       pickup.DisplayName(api.session, guildID, id),
+		FriendCode: p.FriendCode,
+		Region:     p.Region(),
+		State:      registry.State(id).String(),
+		Queues:     []string{},
+		Ban:        banStore.GetBan(id),
+	}
+	for _, queueType := range queuesContaining(p) {
+		player.Queues = append(player.Queues, queueName(queueType))
+	}
+	for _, room := range activeRooms() {
+		if room.PlayerInRoom(p) {
+			player.Room = room.ID
+		}
+	}
+	if team := teamStore.GetPlayerTeam(id); team != nil {
+		player.Team = team.Name
+	}
+	return player
+}
+
+// newRoomJSON describes a room.
+func newRoomJSON(room *pickup.Room) roomJSON {
+	return roomJSON{
+		ID:          room.ID,
+		Queue:       queueName(room.QueueType),
+		Mode:        room.Mode,
+		Created:     room.Created,
+		TextChannel: room.TextChannel,
+		Players:     append([]string{}, playerIDs(room.PlayerList())...),
+	}
+}
+
+// writeJSON sends a value as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Error occurred while writing JSON response", "err", err)
+	}
+}
+
+// writeError sends an error as a JSON response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// avoidPlayer adds a player to the author's avoid list so they are never matched together.
+func avoidPlayer(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !avoid @user"), m.Author.ID))
+		return
+	}
+
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can avoid players."), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(input[1])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, input[1]))
+		return
+	}
+	if id == m.Author.ID {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You cannot avoid yourself.", m.Author.ID))
+		return
+	}
+
+	playerStore.AddAvoid(m.Author.ID, id)
+	if p, ok := registry.Get(m.Author.ID); ok {
+		p.Avoid(id)
+	}
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You will no longer be matched with %s.", m.Author.ID, input[1]))
+}
+
+// unavoidPlayer removes a player from the author's avoid list.
+func unavoidPlayer(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !unavoid @user"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(input[1])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, input[1]))
+		return
+	}
+
+	playerStore.RemoveAvoid(m.Author.ID, id)
+	if p, ok := registry.Get(m.Author.ID); ok {
+		p.Unavoid(id)
+	}
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You can be matched with %s again.", m.Author.ID, input[1]))
+}
+
+// listAvoids lists the players on the author's avoid list.
+func listAvoids(s *discordgo.Session, m *discordgo.MessageCreate) {
+	avoids := playerStore.GetAvoids(m.Author.ID)
+	if len(avoids) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are not avoiding anyone.", m.Author.ID))
+		return
+	}
+
+	guildID := pickup.GetGuildID(s)
+	msg := fmt.Sprintf("<@%s>: You are avoiding:", m.Author.ID)
+	for _, id := range avoids {
+		msg += "\n" + pickup.DisplayName(s, guildID, id)
+	}
+	s.ChannelMessageSend(m.ChannelID, msg)
+}
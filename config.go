@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krankdud/squidup/pickup"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the names of the environment variables that override the configuration file, such as SQUIDUP_TOKEN.
+const envPrefix = "SQUIDUP_"
+
+// Config is the bot's configuration. Settings come from the defaults, then the configuration file, then environment
+// variables, then the command line flags that were given.
+// Settings tagged reload are applied again on SIGHUP. The others are only read at startup.
+type Config struct {
+	Token     string `yaml:"token" flag:"token"`
+	Database  string `yaml:"database" flag:"database"`
+	LogFormat string `yaml:"log_format" flag:"logformat"`
+	HTTP      string `yaml:"http" flag:"http"`
+	APIToken  string `yaml:"api_token" flag:"apitoken"`
+
+	// Queue sizes
+	PairPlayers    int `yaml:"pair_players"`
+	QuadPlayers    int `yaml:"quad_players"`
+	PrivatePlayers int `yaml:"private_players"`
+
+	// Discord IDs
+	SearchChannel     string `yaml:"search_channel"`
+	LogChannel        string `yaml:"log_channel" flag:"logchannel"`
+	SearchPairRole    string `yaml:"search_pair_role"`
+	SearchQuadRole    string `yaml:"search_quad_role"`
+	SearchPrivateRole string `yaml:"search_private_role"`
+	InProgressRole    string `yaml:"in_progress_role"`
+
+	LogLevel       string        `yaml:"log_level" flag:"loglevel" reload:"true"`
+	CommandPrefix  string        `yaml:"command_prefix" flag:"prefix" reload:"true"`
+	CleanupDelay   time.Duration `yaml:"cleanup_delay" flag:"cleanupdelay" reload:"true"`
+	QueueMaxAge    time.Duration `yaml:"queue_max_age" flag:"queuemaxage" reload:"true"`
+	ConfirmTimeout time.Duration `yaml:"confirm_timeout" flag:"confirmtimeout" reload:"true"`
+	RegionWait     time.Duration `yaml:"region_wait" flag:"regionwait" reload:"true"`
+	Presence       string        `yaml:"presence" flag:"presence" reload:"true"`
+	Announce       string        `yaml:"announce" flag:"announce" reload:"true"`
+	AnnounceAt     string        `yaml:"announce_at" flag:"announceat" reload:"true"`
+	MapList        string        `yaml:"map_list" flag:"maplist" reload:"true"`
+	MaxTeamSize    int           `yaml:"max_team_size" flag:"maxteamsize" reload:"true"`
+
+	// Settings read from the ones above by validate
+	mapPool          *pickup.MapPool
+	presenceRules    map[string]pickup.PresenceRule
+	announceChannels []string
+	announceNeeds    map[int]int
+}
+
+// configSource is where the configuration is read from, so it can be read again on SIGHUP.
+type configSource struct {
+	path     string
+	flags    *Config
+	setFlags map[string]bool
+}
+
+var config *Config
+var configMutex sync.RWMutex
+var source configSource
+
+// defaultConfig gets the settings used when nothing else is given.
+func defaultConfig() *Config {
+	return &Config{
+		Database:          "./pickup.db",
+		LogFormat:         "text",
+		PairPlayers:       2,
+		QuadPlayers:       4,
+		PrivatePlayers:    8,
+		SearchChannel:     "374996197561073665",
+		SearchPairRole:    "374995164222980097",
+		SearchQuadRole:    "380166544560357377",
+		SearchPrivateRole: "380166602563518474",
+		InProgressRole:    "374995237342281732",
+		LogLevel:          "info",
+		CommandPrefix:     "!",
+		CleanupDelay:      10 * time.Minute,
+		QueueMaxAge:       time.Hour,
+		ConfirmTimeout:    5 * time.Minute,
+		RegionWait:        5 * time.Minute,
+		Presence:          "offline=0s,idle=10m:warn",
+		AnnounceAt:        "private=2",
+		MaxTeamSize:       8,
+	}
+}
+
+// currentConfig gets the configuration in use. It must not be modified.
+func currentConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return config
+}
+
+func setConfig(cfg *Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	config = cfg
+}
+
+// loadConfig reads and validates the configuration from its source.
+func loadConfig(src configSource) (*Config, error) {
+	cfg := defaultConfig()
+	if src.path != "" {
+		data, err := os.ReadFile(src.path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read %s: %v", src.path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	cfg.applyFlags(src.flags, src.setFlags)
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides each setting that has an environment variable, named after its key in the file.
+func (cfg *Config) applyEnv() error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("yaml")
+		if key == "" {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Interface().(type) {
+		case string:
+			field.SetString(value)
+		case int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number, not %q", name, value)
+			}
+			field.SetInt(int64(n))
+		case time.Duration:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration such as 10m, not %q", name, value)
+			}
+			field.SetInt(int64(d))
+		}
+	}
+	return nil
+}
+
+// applyFlags overrides each setting whose command line flag was given.
+func (cfg *Config) applyFlags(flags *Config, setFlags map[string]bool) {
+	if flags == nil {
+		return
+	}
+	v := reflect.ValueOf(cfg).Elem()
+	fv := reflect.ValueOf(flags).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Tag.Get("flag"); name != "" && setFlags[name] {
+			v.Field(i).Set(fv.Field(i))
+		}
+	}
+}
+
+// validate checks every setting and reads the settings that need parsing. All problems are reported together.
+func (cfg *Config) validate() error {
+	var problems []string
+	problem := func(key string, format string, args ...interface{}) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Token == "" {
+		problem("token", "a Discord bot token is required, such as with -token or %sTOKEN", envPrefix)
+	}
+	if cfg.Database == "" {
+		problem("database", "a path to the database is required")
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		problem("log_format", "must be text or json, not %q", cfg.LogFormat)
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		problem("log_level", "%v", err)
+	}
+	if cfg.CommandPrefix == "" || strings.ContainsAny(cfg.CommandPrefix, " \t\n%") {
+		problem("command_prefix", "must not be empty or contain spaces or %%")
+	}
+
+	sizes := []struct {
+		key     string
+		players int
+	}{{"pair_players", cfg.PairPlayers}, {"quad_players", cfg.QuadPlayers}, {"private_players", cfg.PrivatePlayers}}
+	for _, size := range sizes {
+		if size.players < 2 {
+			problem(size.key, "a match needs at least 2 players, not %d", size.players)
+		}
+	}
+	if cfg.PrivatePlayers%2 != 0 {
+		problem("private_players", "must be even so the players can be split into two teams, not %d", cfg.PrivatePlayers)
+	}
+
+	ids := []struct {
+		key string
+		id  string
+	}{
+		{"search_channel", cfg.SearchChannel},
+		{"search_pair_role", cfg.SearchPairRole},
+		{"search_quad_role", cfg.SearchQuadRole},
+		{"search_private_role", cfg.SearchPrivateRole},
+		{"in_progress_role", cfg.InProgressRole},
+	}
+	for _, id := range ids {
+		if !discordIDRegex.MatchString(id.id) {
+			problem(id.key, "must be a Discord ID, not %q", id.id)
+		}
+	}
+	if cfg.LogChannel != "" && !discordIDRegex.MatchString(cfg.LogChannel) {
+		problem("log_channel", "must be a Discord ID, not %q", cfg.LogChannel)
+	}
+
+	if cfg.CleanupDelay < 0 {
+		problem("cleanup_delay", "must not be negative")
+	}
+	if cfg.QueueMaxAge < 0 {
+		problem("queue_max_age", "must not be negative")
+	}
+	if cfg.RegionWait < 0 {
+		problem("region_wait", "must not be negative")
+	}
+	if cfg.ConfirmTimeout <= 0 {
+		problem("confirm_timeout", "must be longer than 0")
+	}
+	if cfg.MaxTeamSize < pickup.ScrimTeamSize {
+		problem("max_team_size", "a team needs room for at least %d members, not %d", pickup.ScrimTeamSize, cfg.MaxTeamSize)
+	}
+
+	if policy, err := pickup.ParsePresencePolicy(cfg.Presence); err != nil {
+		problem("presence", "%v", err)
+	} else {
+		cfg.presenceRules = policy.Rules
+	}
+
+	sizesByType := map[int]int{pickup.Pair: cfg.PairPlayers, pickup.Quad: cfg.QuadPlayers, pickup.Private: cfg.PrivatePlayers}
+	if needs, err := parseAnnounceNeeds(cfg.AnnounceAt, sizesByType); err != nil {
+		problem("announce_at", "%v", err)
+	} else {
+		cfg.announceNeeds = needs
+	}
+	cfg.announceChannels = nil
+	for _, id := range strings.Split(cfg.Announce, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if !discordIDRegex.MatchString(id) {
+			problem("announce", "must be Discord channel IDs separated by commas, not %q", id)
+		}
+		cfg.announceChannels = append(cfg.announceChannels, id)
+	}
+
+	cfg.mapPool = pickup.DefaultMapPool()
+	if cfg.MapList != "" {
+		if pool, err := pickup.LoadMapPool(cfg.MapList); err != nil {
+			problem("map_list", "%v", err)
+		} else {
+			cfg.mapPool = pool
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// applyConfig puts the settings that can be reloaded into effect.
+func applyConfig(cfg *Config) {
+	level, _ := parseLogLevel(cfg.LogLevel)
+	logLevel.Set(level)
+	pickup.SetCommandPrefix(cfg.CommandPrefix)
+	presencePolicy.SetRules(cfg.presenceRules)
+	for _, q := range searchQueues {
+		q.SetRegionWait(cfg.RegionWait)
+	}
+}
+
+// reloadConfig reads the configuration again and applies the settings that can change while the bot is running.
+// Changes to the other settings are ignored until the bot is restarted. An invalid configuration is not applied.
+func reloadConfig() {
+	cfg, err := loadConfig(source)
+	if err != nil {
+		slog.Error("Configuration was not reloaded", "err", err)
+		return
+	}
+
+	old := currentConfig()
+	v := reflect.ValueOf(cfg).Elem()
+	ov := reflect.ValueOf(old).Elem()
+	reverted := false
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" || field.Tag.Get("reload") != "" {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), ov.Field(i).Interface()) {
+			slog.Warn("Setting changed but needs a restart to apply", "setting", field.Tag.Get("yaml"))
+			v.Field(i).Set(ov.Field(i))
+			reverted = true
+		}
+	}
+
+	// Settings such as the announce thresholds are read using the queue sizes, so read them again with the sizes in use
+	if reverted {
+		if err := cfg.validate(); err != nil {
+			slog.Error("Configuration was not reloaded", "err", err)
+			return
+		}
+	}
+
+	setConfig(cfg)
+	applyConfig(cfg)
+	slog.Info("Configuration reloaded")
+}
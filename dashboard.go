@@ -0,0 +1,231 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// dashboardInterval is how often the dashboard state is checked for changes.
+const dashboardInterval = 2 * time.Second
+
+// dashboardHistory is how many recent matches the dashboard shows.
+const dashboardHistory = 15
+
+// dashboardLeaders is how many teams and players each dashboard leaderboard shows.
+const dashboardLeaders = 10
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+type dashboardQueue struct {
+	Name            string   `json:"name"`
+	RequiredPlayers int      `json:"requiredPlayers"`
+	Players         []string `json:"players"`
+}
+
+type dashboardRoom struct {
+	ID      int       `json:"id"`
+	Queue   string    `json:"queue"`
+	Mode    string    `json:"mode,omitempty"`
+	Created time.Time `json:"created"`
+	Players []string  `json:"players"`
+}
+
+type dashboardMatch struct {
+	Time    time.Time `json:"time"`
+	RoomID  int       `json:"room,omitempty"`
+	Details string    `json:"details"`
+	Players []string  `json:"players,omitempty"`
+}
+
+type dashboardTeam struct {
+	Name   string `json:"name"`
+	Tag    string `json:"tag"`
+	Wins   int    `json:"wins"`
+	Losses int    `json:"losses"`
+	Rating int    `json:"rating"`
+}
+
+type dashboardPlayer struct {
+	Name    string `json:"name"`
+	Matches int    `json:"matches"`
+}
+
+type dashboardState struct {
+	Queues  []dashboardQueue  `json:"queues"`
+	Rooms   []dashboardRoom   `json:"rooms"`
+	History []dashboardMatch  `json:"history"`
+	Teams   []dashboardTeam   `json:"teams"`
+	Players []dashboardPlayer `json:"players"`
+}
+
+// dashboardClients are the channels of the viewers connected to the dashboard's event stream.
+var dashboardClients = make(map[chan []byte]bool)
+var dashboardLast []byte
+var dashboardMutex sync.Mutex
+
+var dashboardOnce sync.Once
+
+// startDashboard serves the read-only dashboard page and its event stream, and starts pushing changes to viewers.
+func startDashboard(s *discordgo.Session, mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		streamDashboard(s, w, r)
+	})
+
+	dashboardOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(dashboardInterval)
+				pushDashboard(s)
+			}
+		}()
+	})
+}
+
+// streamDashboard sends the dashboard state to a viewer as Server-Sent Events whenever it changes.
+func streamDashboard(s *discordgo.Session, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	updates := make(chan []byte, 1)
+	dashboardMutex.Lock()
+	dashboardClients[updates] = true
+	dashboardMutex.Unlock()
+	defer func() {
+		dashboardMutex.Lock()
+		delete(dashboardClients, updates)
+		dashboardMutex.Unlock()
+	}()
+
+	state, err := dashboardJSON(s)
+	if err != nil {
+		slog.Error("Error occurred while building dashboard state", "err", err)
+		http.Error(w, "could not build the dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		fmt.Fprintf(w, "data: %s\n\n", state)
+		flusher.Flush()
+
+		select {
+		case state = <-updates:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// pushDashboard sends the dashboard state to every viewer if it has changed since it was last sent.
+func pushDashboard(s *discordgo.Session) {
+	dashboardMutex.Lock()
+	viewers := len(dashboardClients)
+	dashboardMutex.Unlock()
+	if viewers == 0 {
+		return
+	}
+
+	state, err := dashboardJSON(s)
+	if err != nil {
+		slog.Error("Error occurred while building dashboard state", "err", err)
+		return
+	}
+
+	dashboardMutex.Lock()
+	defer dashboardMutex.Unlock()
+
+	if string(state) == string(dashboardLast) {
+		return
+	}
+	dashboardLast = state
+	for updates := range dashboardClients {
+		// A viewer that has not read the last update only needs the newest one
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- state
+	}
+}
+
+// dashboardJSON builds the dashboard state from the queues, rooms and database.
+func dashboardJSON(s *discordgo.Session) ([]byte, error) {
+	guildID := pickup.GetGuildID(s)
+	names := func(ids []string) []string {
+		list := []string{}
+		for _, id := range ids {
+			list = append(list, pickup.DisplayName(s, guildID, id))
+		}
+		return list
+	}
+
+	state := dashboardState{
+		Queues:  []dashboardQueue{},
+		Rooms:   []dashboardRoom{},
+		History: []dashboardMatch{},
+		Teams:   []dashboardTeam{},
+		Players: []dashboardPlayer{},
+	}
+
+	for _, queueType := range []int{pickup.Pair, pickup.Quad, pickup.Private} {
+		q := searchQueues[queueType]
+		state.Queues = append(state.Queues, dashboardQueue{
+			Name:            queueName(queueType),
+			RequiredPlayers: q.RequiredPlayers,
+			Players:         names(playerIDs(q.Snapshot())),
+		})
+	}
+
+	for _, room := range activeRooms() {
+		state.Rooms = append(state.Rooms, dashboardRoom{
+			ID:      room.ID,
+			Queue:   queueName(room.QueueType),
+			Mode:    room.Mode,
+			Created: room.Created,
+			Players: names(playerIDs(room.PlayerList())),
+		})
+	}
+
+	for _, event := range eventLog.Store.GetRecentEvents([]string{pickup.EventRoomCreated, pickup.EventResult}, dashboardHistory) {
+		match := dashboardMatch{Time: event.Time, RoomID: event.RoomID, Details: event.Details}
+		// Results are logged for the player who reported them, not the players in the match
+		if event.Type == pickup.EventRoomCreated {
+			match.Players = names(event.PlayerIDs)
+		}
+		state.History = append(state.History, match)
+	}
+
+	for i, team := range teamStore.GetTeams() {
+		if i == dashboardLeaders {
+			break
+		}
+		state.Teams = append(state.Teams, dashboardTeam{Name: team.Name, Tag: team.Tag, Wins: team.Wins, Losses: team.Losses, Rating: team.Rating})
+	}
+
+	for _, count := range eventLog.Store.GetTopPlayers(pickup.EventRoomCreated, dashboardLeaders) {
+		state.Players = append(state.Players, dashboardPlayer{Name: pickup.DisplayName(s, guildID, count.PlayerID), Matches: count.Count})
+	}
+
+	return json.Marshal(state)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// expiryCheckInterval is how often the queues are checked for players who may have stopped searching.
+const expiryCheckInterval = time.Minute
+
+// confirmEmoji is the reaction players use to confirm they are still searching.
+const confirmEmoji = "✅"
+
+// confirmPrompt is a message asking a player to confirm they are still searching.
+type confirmPrompt struct {
+	channelID string
+	messageID string
+	sent      time.Time
+}
+
+var confirmPrompts = make(map[string]*confirmPrompt)
+var confirmMutex sync.Mutex
+
+var expiryOnce sync.Once
+
+// startQueueExpiry starts checking the queues for stale players.
+func startQueueExpiry(s *discordgo.Session) {
+	expiryOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(expiryCheckInterval)
+				expireQueues(s)
+			}
+		}()
+	})
+}
+
+// expireQueues asks players who have been searching longer than the max age to confirm,
+// and removes players who did not confirm in time. A max age of zero turns off expiry.
+func expireQueues(s *discordgo.Session) {
+	cfg := currentConfig()
+	if cfg.QueueMaxAge <= 0 {
+		return
+	}
+
+	// Teams in the scrim queue are confirmed by their captain
+	stale := make(map[*pickup.Player]bool)
+	for _, q := range searchQueues {
+		for _, p := range q.Stale(cfg.QueueMaxAge) {
+			stale[p] = true
+		}
+	}
+	for _, p := range scrimQueue.Stale(cfg.QueueMaxAge) {
+		stale[p] = true
+	}
+
+	confirmMutex.Lock()
+	defer confirmMutex.Unlock()
+
+	// Forget prompts for players who have since left the queues or been matched
+	for id := range confirmPrompts {
+		if p, ok := registry.Get(id); !ok || !stale[p] {
+			delete(confirmPrompts, id)
+		}
+	}
+
+	guildID := pickup.GetGuildID(s)
+	for p := range stale {
+		if registry.State(p.ID) != pickup.StateSearching {
+			continue
+		}
+
+		prompt, ok := confirmPrompts[p.ID]
+		if !ok {
+			sent, err := sendConfirmPrompt(s, p.ID)
+			if err != nil {
+				slog.Warn("Error occurred while asking a player to confirm", "player", p.ID, "err", err)
+				continue
+			}
+			confirmPrompts[p.ID] = sent
+			continue
+		}
+
+		if time.Since(prompt.sent) > cfg.ConfirmTimeout {
+			delete(confirmPrompts, p.ID)
+			removeFromQueues(s, guildID, p, "did not confirm they were still searching")
+			s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("<@%s>: You have been removed from the queue because you did not confirm you were still searching.", p.ID))
+		}
+	}
+}
+
+// sendConfirmPrompt asks a player to react if they are still searching. The prompt is sent by DM, or posted in the search channel if the player does not accept DMs.
+func sendConfirmPrompt(s *discordgo.Session, playerID string) (*confirmPrompt, error) {
+	msg := fmt.Sprintf("You have been searching for a while. React with %s within %s if you are still searching, or you will be removed from the queue.", confirmEmoji, currentConfig().ConfirmTimeout)
+
+	var message *discordgo.Message
+	channel, err := s.UserChannelCreate(playerID)
+	if err == nil {
+		message, err = s.ChannelMessageSend(channel.ID, msg)
+	}
+	if err != nil {
+		message, err = s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("<@%s>: %s", playerID, msg))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.MessageReactionAdd(message.ChannelID, message.ID, confirmEmoji)
+	return &confirmPrompt{channelID: message.ChannelID, messageID: message.ID, sent: time.Now()}, nil
+}
+
+// confirmSearching keeps a player in their queues if the reaction confirms their prompt.
+// Returns true if the reaction was for a prompt.
+func confirmSearching(s *discordgo.Session, r *discordgo.MessageReactionAdd) bool {
+	confirmMutex.Lock()
+	prompt, ok := confirmPrompts[r.UserID]
+	if !ok || prompt.messageID != r.MessageID || r.Emoji.Name != confirmEmoji {
+		confirmMutex.Unlock()
+		return false
+	}
+	delete(confirmPrompts, r.UserID)
+	confirmMutex.Unlock()
+
+	p, ok := registry.Get(r.UserID)
+	if !ok || registry.State(p.ID) != pickup.StateSearching {
+		return true
+	}
+	for _, q := range searchQueues {
+		q.Refresh(p)
+	}
+	scrimQueue.Refresh(p)
+	s.ChannelMessageSend(prompt.channelID, fmt.Sprintf("<@%s>: Thanks! You are still in the queue.", p.ID))
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the lowest level of log lines that are written. It can change while the bot is running.
+var logLevel slog.LevelVar
+
+// parseLogLevel reads a level such as "debug", "info", "warn" or "error".
+func parseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, fmt.Errorf("unknown log level %q, must be debug, info, warn or error", level)
+	}
+	return l, nil
+}
+
+// setupLogging makes the default logger write lines at or above logLevel in a format ("text" or "json").
+func setupLogging(format string) error {
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, must be text or json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -4,11 +4,12 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/bwmarrin/discordgo"
@@ -17,62 +18,132 @@ import (
 )
 
 var pairQueue, quadQueue, privateQueue pickup.Queue
+var scrimQueue pickup.TeamQueue
 var rooms []*pickup.Room
+var roomsMutex sync.Mutex
 var friendCodeRegex *regexp.Regexp
 var memberRegex *regexp.Regexp
 var database *sql.DB
 var playerStore pickup.PlayerStore
-var players map[string]*pickup.Player
+var banStore pickup.BanStore
+var teamStore pickup.TeamStore
+var scheduleStore pickup.ScheduleStore
+var registry *pickup.Registry
+var eventLog *pickup.EventLog
+var presencePolicy = pickup.DefaultPresencePolicy()
+
+// searchQueues are the queues a player can search in at the same time, by queue type.
+var searchQueues = map[int]*pickup.Queue{
+	pickup.Pair:    &pairQueue,
+	pickup.Quad:    &quadQueue,
+	pickup.Private: &privateQueue,
+}
 
 func init() {
-	pairQueue.RequiredPlayers = 2
-	quadQueue.RequiredPlayers = 4
-	privateQueue.RequiredPlayers = 8
 	friendCodeRegex = regexp.MustCompile(`\d{4}-\d{4}-\d{4}`)
-	memberRegex = regexp.MustCompile(`<@\d*>`)
-	players = make(map[string]*pickup.Player)
+	memberRegex = regexp.MustCompile(`^<@!?(\d+)>$`)
+	registry = pickup.NewRegistry()
 }
 
 func main() {
-	createDatabase()
-
-	var token string
-	flag.StringVar(&token, "token", "", "Discord bot API token")
+	flags := defaultConfig()
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "Path to a YAML configuration file. Environment variables such as SQUIDUP_TOKEN override it, and flags override both")
+	flag.StringVar(&flags.Token, "token", "", "Discord bot API token")
+	flag.StringVar(&flags.Database, "database", flags.Database, "Path to the SQLite database")
+	flag.StringVar(&flags.LogChannel, "logchannel", "", "ID of the channel where bot events are posted")
+	flag.StringVar(&flags.MapList, "maplist", "", "Path to a JSON or YAML map pool for private battle sets")
+	flag.StringVar(&flags.CommandPrefix, "prefix", flags.CommandPrefix, "Text that starts every command")
+	flag.DurationVar(&flags.CleanupDelay, "cleanupdelay", flags.CleanupDelay, "How long a room stays open after a player leaves it")
+	flag.DurationVar(&flags.QueueMaxAge, "queuemaxage", flags.QueueMaxAge, "How long a player can search before being asked to confirm, or 0 to never ask")
+	flag.DurationVar(&flags.ConfirmTimeout, "confirmtimeout", flags.ConfirmTimeout, "How long a player has to confirm they are still searching")
+	flag.StringVar(&flags.Presence, "presence", flags.Presence, "Rules for removing searching players by status, such as \"offline=0s,idle=10m:warn,dnd=30m\"")
+	flag.StringVar(&flags.Announce, "announce", "", "Comma separated IDs of channels where queues that need a few more players are announced")
+	flag.StringVar(&flags.AnnounceAt, "announceat", flags.AnnounceAt, "How many more players each queue needs before it is announced, such as \"private=2,quad=1\"")
+	flag.IntVar(&flags.MaxTeamSize, "maxteamsize", flags.MaxTeamSize, "Most members a team can have")
+	flag.DurationVar(&flags.RegionWait, "regionwait", flags.RegionWait, "How long players wait before they can be matched with players from other regions")
+	flag.StringVar(&flags.HTTP, "http", "", "Address to serve the dashboard, metrics and HTTP admin API on, such as \":8080\"")
+	flag.StringVar(&flags.APIToken, "apitoken", "", "Bearer token required by the HTTP admin API. The API is disabled without one")
+	flag.StringVar(&flags.LogLevel, "loglevel", flags.LogLevel, "Lowest level of log lines to write: debug, info, warn or error")
+	flag.StringVar(&flags.LogFormat, "logformat", flags.LogFormat, "Format of log lines: text or json")
 	flag.Parse()
 
-	if token == "" {
-		fmt.Println("Token must be provided to run the bot")
-		return
+	source = configSource{path: configPath, flags: flags, setFlags: make(map[string]bool)}
+	flag.Visit(func(f *flag.Flag) { source.setFlags[f.Name] = true })
+	cfg, err := loadConfig(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading configuration:", err)
+		os.Exit(1)
+	}
+	setConfig(cfg)
+	if err := setupLogging(cfg.LogFormat); err != nil {
+		fatal("Error setting up logging", "err", err)
 	}
 
-	dg, err := discordgo.New("Bot " + token)
+	pairQueue.RequiredPlayers = cfg.PairPlayers
+	quadQueue.RequiredPlayers = cfg.QuadPlayers
+	privateQueue.RequiredPlayers = cfg.PrivatePlayers
+	pickup.SearchChannelID = cfg.SearchChannel
+	pickup.RoleSearchPair = cfg.SearchPairRole
+	pickup.RoleSearchQuad = cfg.SearchQuadRole
+	pickup.RoleSearchPrivate = cfg.SearchPrivateRole
+	pickup.RoleInProgress = cfg.InProgressRole
+	applyConfig(cfg)
+
+	createDatabase(cfg.Database)
+
+	dg, err := discordgo.New("Bot " + cfg.Token)
 	if err != nil {
-		fmt.Println("Error creating Discord session ", err)
-		return
+		fatal("Error creating Discord session", "err", err)
+	}
+	instrumentDiscord(dg)
+
+	eventLog = &pickup.EventLog{
+		Store:     pickup.SQLiteEventStore{DB: database},
+		Session:   dg,
+		ChannelID: cfg.LogChannel,
+	}
+
+	for queueType, q := range searchQueues {
+		queueType := queueType
+		q.Grew = func(before int, after int) { queueGrew(dg, queueType, before, after) }
 	}
 
 	dg.AddHandler(messageCreate)
 	dg.AddHandler(presenceUpdate)
+	dg.AddHandler(messageReactionAdd)
+	dg.AddHandler(guildCreate)
+	dg.AddHandler(guildRoleCreate)
+	dg.AddHandler(guildRoleUpdate)
+	dg.AddHandler(guildRoleDelete)
 
 	err = dg.Open()
 	if err != nil {
-		fmt.Println("Error opening connection ", err)
-		return
+		fatal("Error opening connection", "err", err)
 	}
 
-	fmt.Println("Bot is running. Press CTRL-C to exit.")
+	startQueueExpiry(dg)
+	startScheduler(dg)
+	startRematching(dg)
+	if cfg.HTTP != "" {
+		startHTTP(dg, cfg.HTTP, cfg.APIToken)
+	}
+
+	slog.Info("Bot is running. Press CTRL-C to exit, or send SIGHUP to reload the configuration.")
 	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
-	<-sc
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt, os.Kill)
+	for sig := <-sc; sig == syscall.SIGHUP; sig = <-sc {
+		reloadConfig()
+	}
 
 	dg.Close()
 	database.Close()
 }
 
-func createDatabase() {
-	db, err := sql.Open("sqlite3", "./pickup.db")
+func createDatabase(path string) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Error occurred while creating the database", "err", err)
 	}
 
 	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Players (
@@ -82,11 +153,130 @@ func createDatabase() {
 		PRIMARY KEY (ID)
 	);`)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Bans (
+		DiscordID varchar(255) NOT NULL,
+		ModeratorID varchar(255) NOT NULL,
+		Reason text NOT NULL,
+		Expires int NOT NULL,
+		PRIMARY KEY (DiscordID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Avoids (
+		DiscordID varchar(255) NOT NULL,
+		AvoidID varchar(255) NOT NULL,
+		PRIMARY KEY (DiscordID, AvoidID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS EventLog (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		Time int NOT NULL,
+		Type varchar(32) NOT NULL,
+		DiscordID varchar(255) NOT NULL,
+		RoomID int NOT NULL,
+		Details text NOT NULL,
+		EventID int NOT NULL DEFAULT 0
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Teams (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		Name varchar(255) NOT NULL UNIQUE,
+		Tag varchar(8) NOT NULL UNIQUE,
+		CaptainID varchar(255) NOT NULL,
+		Wins int NOT NULL,
+		Losses int NOT NULL,
+		Rating int NOT NULL
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS TeamMembers (
+		TeamID int NOT NULL,
+		DiscordID varchar(255) NOT NULL,
+		PRIMARY KEY (DiscordID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS TeamInvites (
+		TeamID int NOT NULL,
+		DiscordID varchar(255) NOT NULL,
+		PRIMARY KEY (TeamID, DiscordID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Notifications (
+		DiscordID varchar(255) NOT NULL,
+		MatchDM int NOT NULL DEFAULT 0,
+		PingRole int NOT NULL DEFAULT 0,
+		PRIMARY KEY (DiscordID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS NotifyFollows (
+		DiscordID varchar(255) NOT NULL,
+		QueueType int NOT NULL,
+		PRIMARY KEY (DiscordID, QueueType)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ScheduledEvents (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		Name text NOT NULL,
+		Start int NOT NULL,
+		QueueType int NOT NULL,
+		Capacity int NOT NULL,
+		CreatorID varchar(255) NOT NULL,
+		Reminders int NOT NULL DEFAULT 0,
+		Started int NOT NULL DEFAULT 0
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS EventSignups (
+		EventID int NOT NULL,
+		DiscordID varchar(255) NOT NULL,
+		SignedUp int NOT NULL,
+		PRIMARY KEY (EventID, DiscordID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Regions (
+		DiscordID varchar(255) NOT NULL,
+		Region varchar(8) NOT NULL,
+		PRIMARY KEY (DiscordID)
+	);`)
+	if err != nil {
+		fatal("Error occurred while creating the database", "err", err)
 	}
 
 	database = db
 	playerStore = pickup.SQLitePlayerStore{DB: db}
+	banStore = pickup.SQLiteBanStore{DB: db}
+	teamStore = pickup.SQLiteTeamStore{DB: db}
+	scheduleStore = pickup.SQLiteScheduleStore{DB: db}
 }
 
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -94,10 +284,18 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
+	// Commands are handled with "!" in place of the command prefix, so the prefix can be anything
+	content, ok := pickup.ParseCommand(m.Content)
+	if !ok {
+		return
+	}
+	m.Content = content
+
 	input := strings.Split(m.Content, " ")
 	if len(input) < 1 {
 		return
 	}
+	slog.Debug("Command received", "command", input[0], "player", m.Author.ID, "channel", m.ChannelID)
 
 	switch command := input[0]; command {
 	case "!register":
@@ -106,202 +304,640 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 				if friendCodeRegex.MatchString(input[1]) {
 					if playerStore.PlayerExists(m.Author.ID) {
 						playerStore.UpdateFriendCode(m.Author.ID, input[1])
+						eventLog.Log(pickup.EventRegister, 0, "updated friend code", m.Author.ID)
 						s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Your friend code has been updated.", m.Author.ID))
 					} else {
 						playerStore.Register(m.Author.ID, input[1])
-						s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Registered successfully! Use !pair, !quad, or !private to start searching.", m.Author.ID))
+						eventLog.Log(pickup.EventRegister, 0, "registered", m.Author.ID)
+						s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Registered successfully! Use !pair, !quad, or !private to start searching."), m.Author.ID))
 					}
 				} else {
-					s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid friend code. Register by typing \"!register ####-####-####\"", m.Author.ID, input[1]))
+					s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: %s is not a valid friend code. Register by typing \"!register ####-####-####\""), m.Author.ID, input[1]))
 				}
 			} else {
-				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You must provide your friend code when registering. Register by typing \"!register ####-####-####\"", m.Author.ID))
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must provide your friend code when registering. Register by typing \"!register ####-####-####\""), m.Author.ID))
 			}
 		}
 	case "!pair":
 		if m.ChannelID == pickup.SearchChannelID {
-			addToQueue(s, m.Author.ID, m.ChannelID, &pairQueue, pickup.Pair)
+			queueCommand(s, m, input, &pairQueue, pickup.Pair)
 		}
 	case "!quad":
 		if m.ChannelID == pickup.SearchChannelID {
-			addToQueue(s, m.Author.ID, m.ChannelID, &quadQueue, pickup.Quad)
+			queueCommand(s, m, input, &quadQueue, pickup.Quad)
 		}
 	case "!private":
 		if m.ChannelID == pickup.SearchChannelID {
-			addToQueue(s, m.Author.ID, m.ChannelID, &privateQueue, pickup.Private)
+			queueCommand(s, m, input, &privateQueue, pickup.Private)
+		}
+	case "!search":
+		if m.ChannelID == pickup.SearchChannelID {
+			searchCommand(s, m, input)
+		}
+	case "!scrim":
+		if m.ChannelID == pickup.SearchChannelID {
+			addScrimToQueue(s, m, input)
 		}
+	case "!team":
+		teamCommand(s, m, input)
+	case "!result":
+		reportResult(s, m, input)
+	case "!maplist":
+		mapListCommand(s, m, input)
+	case "!pickban":
+		pickBanCommand(s, m, input)
+	case "!notify":
+		notifyCommand(s, m, input)
+	case "!region":
+		regionCommand(s, m, input)
+	case "!event":
+		eventCommand(s, m, input)
+	case "!tournament":
+		tournamentCommand(s, m, input)
+	case "!bracket":
+		showBracket(s, m)
 	case "!leave":
-		if p, ok := players[m.Author.ID]; ok {
+		if p, ok := registry.Get(m.Author.ID); ok {
 			guildID := pickup.GetGuildID(s)
-			if p.IsSearching {
+			if registry.State(p.ID) == pickup.StateSearching {
 				if m.ChannelID == pickup.SearchChannelID {
-					p.IsSearching = false
-					pairQueue.Remove(p)
-					quadQueue.Remove(p)
-					privateQueue.Remove(p)
-					s.GuildMemberRoleRemove(guildID, m.Author.ID, pickup.RoleSearchPair)
-					s.GuildMemberRoleRemove(guildID, m.Author.ID, pickup.RoleSearchQuad)
-					s.GuildMemberRoleRemove(guildID, m.Author.ID, pickup.RoleSearchPrivate)
-					s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have been removed from the queue.", m.Author.ID))
+					// "!leave pair" leaves only that queue, and the player keeps searching in the others
+					if queueType, ok := parseQueueType(strings.Join(input[1:], "")); ok && len(queuesContaining(p)) > 1 {
+						leaveQueue(s, guildID, p, queueType, "left the queue")
+						s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have been removed from the %s queue.", m.Author.ID, queueName(queueType)))
+					} else {
+						removeFromQueues(s, guildID, p, "left the queue")
+						s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have been removed from the queue.", m.Author.ID))
+					}
 				}
 			} else {
-				for _, room := range rooms {
+				for _, room := range activeRooms() {
 					if room.PlayerInRoom(p) && m.ChannelID == room.TextChannel {
-						room.RemovePlayer(p)
-						p.IsInMatch = false
-						s.GuildMemberRoleRemove(guildID, p.ID, pickup.RoleInProgress)
-						for _, c := range room.Channels {
-							s.ChannelPermissionSet(c, p.ID, "member", 0, 1024)
-						}
-
-						for _, p := range room.Players {
-							p.IsInMatch = false
-							s.GuildMemberRoleRemove(guildID, p.ID, pickup.RoleInProgress)
-						}
-
-						if !room.Cleaning {
-							room.Cleaning = true
-							go room.Cleanup(s)
-						}
+						leaveRoom(s, guildID, room, p)
+						finishRoom(s, guildID, room, "A player has left.", "room closed after a player left")
 						break
 					}
 				}
 			}
 		}
+	case "!avoid":
+		avoidPlayer(s, m, input)
+	case "!unavoid":
+		unavoidPlayer(s, m, input)
+	case "!avoids":
+		listAvoids(s, m)
+	case "!mod":
+		moderatorCommand(s, m, input)
 	}
 }
 
 func presenceUpdate(session *discordgo.Session, presence *discordgo.PresenceUpdate) {
-	// Remove a player from the queue if they go offline
-	if p, ok := players[presence.User.ID]; ok {
-		if presence.Status == "offline" && p.IsSearching {
-			pairQueue.Remove(p)
-			quadQueue.Remove(p)
-			privateQueue.Remove(p)
-			session.GuildMemberRoleRemove(presence.GuildID, p.ID, pickup.RoleSearchPair)
-			session.GuildMemberRoleRemove(presence.GuildID, p.ID, pickup.RoleSearchQuad)
-			session.GuildMemberRoleRemove(presence.GuildID, p.ID, pickup.RoleSearchPrivate)
-		}
+	// Only searching players are affected by their presence
+	p, ok := registry.Get(presence.User.ID)
+	if !ok || registry.State(p.ID) != pickup.StateSearching {
+		presencePolicy.Cancel(presence.User.ID)
+		return
 	}
+
+	applyPresence(session, presence.GuildID, p, string(presence.Status))
 }
 
-func addToQueue(s *discordgo.Session, playerID string, channelID string, q *pickup.Queue, queueType int) {
-	if playerStore.PlayerExists(playerID) {
-		// Check if the player is already searching or in a match
-		if p, ok := players[playerID]; ok {
-			if p.IsSearching {
-				s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You must \"!leave\" your current queue before searching again", playerID))
-				return
-			} else if p.IsInMatch {
-				s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You must \"!leave\" your current match before searching again", playerID))
-				return
-			}
-		} else {
-			player := playerStore.GetPlayer(playerID)
-			players[playerID] = player
-		}
+// checkPresence applies the presence policy to a player who has just joined a queue, so that players who are
+// already away when they join are not missed. Players whose presence is not cached are left alone.
+func checkPresence(s *discordgo.Session, guildID string, p *pickup.Player) {
+	if registry.State(p.ID) != pickup.StateSearching {
+		return
+	}
+	presence, err := s.State.Presence(guildID, p.ID)
+	if err != nil {
+		return
+	}
+	applyPresence(s, guildID, p, string(presence.Status))
+}
 
-		player := players[playerID]
-		player.IsSearching = true
+// applyPresence starts or cancels the removal of a searching player for their status, warning them if the rule asks for it.
+func applyPresence(s *discordgo.Session, guildID string, p *pickup.Player, status string) {
+	rule, started := presencePolicy.Update(p.ID, status, func() {
+		dropInactivePlayer(s, guildID, p, status)
+	})
+	if started && rule.Warn && rule.Grace > 0 {
+		s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("<@%s>: You appear to be %s. You will be removed from the queue in %s unless you come back.", p.ID, status, rule.Grace))
+	}
+}
 
-		guildID := pickup.GetGuildID(s)
-		// Add appropriate searching role to the player
-		switch queueType {
-		case pickup.Pair:
-			s.GuildMemberRoleAdd(guildID, playerID, pickup.RoleSearchPair)
-		case pickup.Quad:
-			s.GuildMemberRoleAdd(guildID, playerID, pickup.RoleSearchQuad)
-		case pickup.Private:
-			s.GuildMemberRoleAdd(guildID, playerID, pickup.RoleSearchPrivate)
+// dropInactivePlayer removes a player from every queue after their status stayed inactive for the grace period.
+func dropInactivePlayer(s *discordgo.Session, guildID string, p *pickup.Player, status string) {
+	if registry.State(p.ID) != pickup.StateSearching {
+		return
+	}
+
+	removeFromQueues(s, guildID, p, "went "+status)
+	presenceRemovals.WithLabelValues(status).Inc()
+	s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("<@%s> has been removed from the queue for being %s.", p.ID, status))
+}
+
+func addToQueue(s *discordgo.Session, playerID string, channelID string, q *pickup.Queue, queueType int, modes []string) {
+	if ban := banStore.GetBan(playerID); ban != nil {
+		s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You are banned from matchmaking %s", playerID, banDescription(ban)))
+		return
+	}
+
+	if playerStore.PlayerExists(playerID) {
+		player := registry.Load(playerID, playerStore)
+
+		// Check if the player is already in this queue or in a match
+		if q.Contains(player) {
+			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You are already in the %s queue.", playerID, queueName(queueType)))
+			return
+		}
+		if !startSearching(player) {
+			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: %s", playerID, busyMessage(registry.State(playerID))))
+			return
 		}
 
-		room := q.Enqueue(player)
+		room := joinQueue(s, pickup.GetGuildID(s), player, q, queueType, modes)
 		if room == nil {
-			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You have been added to the queue", playerID))
+			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You have been added to the %s queue", playerID, queueDescription(queueType, modes)))
 		} else {
-			room.SetupRoom(s, queueType)
-			rooms = append(rooms, room)
+			startRoom(s, room, queueType)
 		}
 	} else {
-		s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You must \"!register\" before you can search for matches.", playerID))
+		s.ChannelMessageSend(channelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can search for matches."), playerID))
 	}
 }
 
-func addTeamToQueue(s *discordgo.Session, playerID string, channelID string, input []string, q *pickup.Queue, queueType int) {
-	var team []*pickup.Player
+// startSearching moves a player to the searching state. Players who are already searching can search in more queues,
+// unless their team is in the scrim queue. Returns false if the player cannot search.
+func startSearching(player *pickup.Player) bool {
+	if registry.Transition(player.ID, pickup.StateIdle, pickup.StateSearching) == nil {
+		return true
+	}
+	return registry.State(player.ID) == pickup.StateSearching && !scrimQueue.Contains(player)
+}
 
-	if !playerStore.PlayerExists(playerID) {
-		s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You must \"!register\" before you can search for matches.", playerID))
+// joinQueue adds a searching player to a queue and gives them the queue's searching role.
+// Returns the room that was created if the queue became filled.
+func joinQueue(s *discordgo.Session, guildID string, player *pickup.Player, q *pickup.Queue, queueType int, modes []string) *pickup.Room {
+	pickup.AddRole(s, guildID, player.ID, pickup.SearchRole(queueType))
+	eventLog.Log(pickup.EventEnqueue, 0, queueDescription(queueType, modes), player.ID)
+	room := q.Enqueue(player, modes)
+	checkPresence(s, guildID, player)
+	return room
+}
+
+// searchCommand adds the author to several queues at once, such as "!search pair quad".
+// Modes apply to the league queues. When one queue finds a match, the author is removed from the others.
+func searchCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	var queueTypes []int
+	var modes []string
+	for _, arg := range input[1:] {
+		if queueType, ok := parseQueueType(arg); ok {
+			queueTypes = append(queueTypes, queueType)
+		} else if mode, ok := pickup.ParseMode(arg); ok {
+			modes = append(modes, mode)
+		} else {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a queue or mode.", m.Author.ID, arg))
+			return
+		}
+	}
+	if len(queueTypes) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !search pair|quad|private... [modes]"), m.Author.ID))
 		return
 	}
 
-	if p, ok := players[playerID]; ok {
-		if p.IsSearching {
-			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You must \"!leave\" your current queue before searching again", playerID))
+	if ban := banStore.GetBan(m.Author.ID); ban != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are banned from matchmaking %s", m.Author.ID, banDescription(ban)))
+		return
+	}
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can search for matches."), m.Author.ID))
+		return
+	}
+
+	player := registry.Load(m.Author.ID, playerStore)
+	if !startSearching(player) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s", m.Author.ID, busyMessage(registry.State(m.Author.ID))))
+		return
+	}
+
+	guildID := pickup.GetGuildID(s)
+	var joined []string
+	for _, queueType := range queueTypes {
+		q := searchQueues[queueType]
+		if q.Contains(player) {
+			continue
+		}
+
+		queueModes := modes
+		if queueType == pickup.Private {
+			queueModes = nil
+		}
+		if room := joinQueue(s, guildID, player, q, queueType, queueModes); room != nil {
+			startRoom(s, room, queueType)
 			return
-		} else if p.IsInMatch {
-			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You must \"!leave\" your current match before searching again", playerID))
+		}
+		joined = append(joined, queueDescription(queueType, queueModes))
+	}
+
+	if len(joined) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are already in those queues.", m.Author.ID))
+		return
+	}
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have been added to the %s queues.", m.Author.ID, strings.Join(joined, ", ")))
+}
+
+// queueCommand handles the commands for joining a queue. Any mentioned players, or the members of a team
+// given as "team:<tag>", join the queue together with the author. League queues also take the modes to play, such as "zones".
+func queueCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string, q *pickup.Queue, queueType int) {
+	var memberIDs []string
+	var modes []string
+	var team *pickup.Team
+	for _, arg := range input[1:] {
+		if mode, ok := pickup.ParseMode(arg); ok {
+			if queueType != pickup.Pair && queueType != pickup.Quad {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Modes can only be chosen for !pair and !quad."), m.Author.ID))
+				return
+			}
+			modes = append(modes, mode)
+		} else if strings.HasPrefix(arg, "team:") {
+			team = teamStore.GetTeamByTag(strings.TrimPrefix(arg, "team:"))
+			if team == nil {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no team with the tag %s.", m.Author.ID, strings.TrimPrefix(arg, "team:")))
+				return
+			}
+		} else if id, ok := parseMention(arg); ok {
+			if id == m.Author.ID {
+				continue
+			}
+			if containsString(memberIDs, id) {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> was mentioned more than once.", m.Author.ID, id))
+				return
+			}
+			memberIDs = append(memberIDs, id)
+		} else {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, arg))
 			return
 		}
-	} else {
-		player := playerStore.GetPlayer(playerID)
-		players[playerID] = player
 	}
 
-	team = append(team, players[playerID])
+	if team != nil {
+		if !team.HasMember(m.Author.ID) {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are not a member of %s.", m.Author.ID, team.Name))
+			return
+		}
 
-	// Make sure each team member can be added to the team
-	for i := 1; i < len(input); i++ {
-		if memberRegex.MatchString(input[i]) {
-			id := input[i][2 : len(input[i])-1]
-			if playerStore.PlayerExists(id) {
-				if p, ok := players[id]; ok {
-					if p.IsSearching {
-						s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: %s must \"!leave\" his or her current queue before searching with a team", playerID, input[i]))
-						return
-					} else if p.IsInMatch {
-						s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: %s must \"!leave\" his or her current match before searching with a team", playerID, input[i]))
-						return
-					}
-				} else {
-					player := playerStore.GetPlayer(id)
-					players[id] = player
+		// Without mentions the whole team plays, otherwise only the mentioned members do
+		if len(memberIDs) == 0 {
+			for _, id := range team.Members {
+				if id != m.Author.ID {
+					memberIDs = append(memberIDs, id)
 				}
-
-				team = append(team, players[id])
-			} else {
-				s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: %s needs to be registered before queuing.", playerID, input[i]))
+			}
+		}
+		for _, id := range memberIDs {
+			if !team.HasMember(id) {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is not a member of %s.", m.Author.ID, id, team.Name))
 				return
 			}
+		}
+	}
+
+	if team == nil && len(memberIDs) == 0 {
+		addToQueue(s, m.Author.ID, m.ChannelID, q, queueType, modes)
+		return
+	}
+
+	if len(memberIDs)+1 > q.RequiredPlayers {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: At most %d players can queue together for %s. Mention the members who are playing.", m.Author.ID, q.RequiredPlayers, queueName(queueType)))
+		return
+	}
+
+	addTeamToQueue(s, m.Author.ID, m.ChannelID, memberIDs, q, queueType, modes)
+}
+
+func addTeamToQueue(s *discordgo.Session, playerID string, channelID string, memberIDs []string, q *pickup.Queue, queueType int, modes []string) {
+	var team []*pickup.Player
+
+	if !playerStore.PlayerExists(playerID) {
+		s.ChannelMessageSend(channelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can search for matches."), playerID))
+		return
+	}
+
+	if ban := banStore.GetBan(playerID); ban != nil {
+		s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: You are banned from matchmaking %s", playerID, banDescription(ban)))
+		return
+	}
+
+	team = append(team, registry.Load(playerID, playerStore))
+
+	// Make sure each team member can be added to the team
+	for _, id := range memberIDs {
+		if ban := banStore.GetBan(id); ban != nil {
+			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: <@%s> is banned from matchmaking %s", playerID, id, banDescription(ban)))
+			return
+		}
+
+		if playerStore.PlayerExists(id) {
+			team = append(team, registry.Load(id, playerStore))
 		} else {
-			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", playerID, input[i]))
+			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: <@%s> needs to be registered before queuing.", playerID, id))
 			return
 		}
 	}
 
+	var ids []string
+	for _, player := range team {
+		ids = append(ids, player.ID)
+	}
+
+	// Make sure every team member can start searching
+	if err := registry.TransitionAll(ids, pickup.StateIdle, pickup.StateSearching); err != nil {
+		if err, ok := err.(*pickup.TransitionError); ok && err.PlayerID != playerID {
+			s.ChannelMessageSend(channelID, fmt.Sprintf(pickup.Commands("<@%s>: <@%s> must \"!leave\" their current queue or match before searching with a team"), playerID, err.PlayerID))
+		} else {
+			s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: %s", playerID, busyMessage(registry.State(playerID))))
+		}
+		return
+	}
+
 	guildID := pickup.GetGuildID(s)
 
 	// Set roles for each team member
 	for _, player := range team {
-		players[player.ID] = player
-		player.IsSearching = true
-
 		// Add appropriate searching role to the player
-		switch queueType {
-		case pickup.Pair:
-			s.GuildMemberRoleAdd(guildID, player.ID, pickup.RoleSearchPair)
-		case pickup.Quad:
-			s.GuildMemberRoleAdd(guildID, player.ID, pickup.RoleSearchQuad)
-		case pickup.Private:
-			s.GuildMemberRoleAdd(guildID, player.ID, pickup.RoleSearchPrivate)
-		}
+		pickup.AddRole(s, guildID, player.ID, pickup.SearchRole(queueType))
 	}
 
-	room := q.EnqueueTeam(team)
+	eventLog.Log(pickup.EventEnqueue, 0, queueDescription(queueType, modes)+" as a team", ids...)
+
+	room := q.EnqueueTeam(team, modes)
 	if room == nil {
-		s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: Your team has been added to the queue.", playerID))
+		s.ChannelMessageSend(channelID, fmt.Sprintf("<@%s>: Your team has been added to the %s queue.", playerID, queueDescription(queueType, modes)))
 	} else {
-		room.SetupRoom(s, queueType)
-		rooms = append(rooms, room)
+		startRoom(s, room, queueType)
+	}
+	for _, player := range team {
+		checkPresence(s, guildID, player)
+	}
+}
+
+// busyMessage explains why a player in the given state cannot join a queue.
+func busyMessage(state pickup.PlayerState) string {
+	switch state {
+	case pickup.StateReadyCheck:
+		return "A match has been found for you and its room is being set up."
+	case pickup.StateInMatch:
+		return pickup.Commands("You must \"!leave\" your current match before searching again")
+	}
+	return pickup.Commands("You must \"!leave\" your current queue before searching again")
+}
+
+// banDescription describes when a ban expires and why it was given.
+func banDescription(ban *pickup.Ban) string {
+	return fmt.Sprintf("until %s. Reason: %s", ban.Expires.Format("Jan 2 15:04 MST"), ban.Reason)
+}
+
+// startRoom creates the channels for a room filled by a queue and adds it to the active rooms.
+// If the room cannot be set up, its players are returned to the queue and the failure is reported in the search channel.
+func startRoom(s *discordgo.Session, room *pickup.Room, queueType int) {
+	var ids []string
+	var mentions []string
+	for _, p := range room.PlayerList() {
+		ids = append(ids, p.ID)
+		mentions = append(mentions, "<@"+p.ID+">")
+	}
+
+	if err := registry.TransitionAll(ids, pickup.StateSearching, pickup.StateReadyCheck); err != nil {
+		// A player stopped searching while the room was being formed, so put everyone else back
+		room.Logger().Warn("Room could not be formed", "queue", queueName(queueType), "players", ids, "err", err)
+		room.ReturnToQueue()
+		guildID := pickup.GetGuildID(s)
+		for _, p := range room.PlayerList() {
+			switch registry.State(p.ID) {
+			case pickup.StateSearching:
+			case pickup.StateReadyCheck:
+				// A room from another queue is being set up for the player, who leaves this queue only if it succeeds
+			case pickup.StateIdle:
+				removeFromQueues(s, guildID, p, "stopped searching while a room was formed")
+			default:
+				// Another queue found a match for the player first
+				leaveQueue(s, guildID, p, queueType, "matched in another queue")
+			}
+		}
+		return
+	}
+
+	if queueType == pickup.Private {
+		if maps, err := currentConfig().mapPool.Generate(); err == nil {
+			room.SetMaps(maps)
+		} else {
+			room.Logger().Error("Error occurred while generating map list", "players", ids, "err", err)
+		}
+	}
+
+	err := room.SetupRoom(s, queueType)
+	if err != nil {
+		room.Logger().Error("Error occurred while setting up room", "queue", queueName(queueType), "players", ids, "err", err)
+		registry.TransitionAll(ids, pickup.StateReadyCheck, pickup.StateSearching)
+		room.ReturnToQueue()
+		eventLog.Log(pickup.EventRoomFailed, room.ID, err.Error(), ids...)
+		s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("%s: A match was found, but the room could not be created. You have been returned to the %s queue.", strings.Join(mentions, " "), queueName(queueType)))
+		return
+	}
+
+	if err := registry.TransitionAll(ids, pickup.StateReadyCheck, pickup.StateInMatch); err != nil {
+		room.Logger().Error("Error occurred while starting room", "players", ids, "err", err)
+	}
+
+	// The players stop searching in every other queue they joined once their room is ready
+	guildID := pickup.GetGuildID(s)
+	for _, p := range room.PlayerList() {
+		presencePolicy.Cancel(p.ID)
+		for otherType := range searchQueues {
+			if otherType != queueType {
+				leaveQueue(s, guildID, p, otherType, "matched in the "+queueName(queueType)+" queue")
+			}
+		}
+	}
+	addRoom(room)
+	var modes []string
+	if room.Mode != "" {
+		modes = []string{room.Mode}
+	}
+	eventLog.Log(pickup.EventRoomCreated, room.ID, queueDescription(queueType, modes), ids...)
+	notifyMatchFound(s, room)
+}
+
+// parseQueueType gets the queue type for the name of a queue a player can search in.
+func parseQueueType(name string) (int, bool) {
+	for queueType := range searchQueues {
+		if queueName(queueType) == strings.ToLower(name) {
+			return queueType, true
+		}
+	}
+	return 0, false
+}
+
+// queueName gets the name of a queue type.
+func queueName(queueType int) string {
+	switch queueType {
+	case pickup.Pair:
+		return "pair"
+	case pickup.Quad:
+		return "quad"
+	case pickup.Private:
+		return "private"
+	case pickup.Scrim:
+		return "scrim"
+	}
+	return "unknown"
+}
+
+// queueDescription gets the name of a queue type with the modes a player chose.
+func queueDescription(queueType int, modes []string) string {
+	if len(modes) == 0 {
+		return queueName(queueType)
+	}
+	return fmt.Sprintf("%s (%s)", queueName(queueType), strings.Join(modes, ", "))
+}
+
+// removeFromQueues removes a player from every queue and takes away their searching roles.
+// reason : Why the player was removed, recorded in the event log
+func removeFromQueues(s *discordgo.Session, guildID string, p *pickup.Player, reason string) {
+	eventLog.Log(pickup.EventDequeue, 0, reason, p.ID)
+	registry.Release(p.ID)
+	presencePolicy.Cancel(p.ID)
+	for _, q := range searchQueues {
+		q.Remove(p)
+	}
+
+	// A team leaves the scrim queue together
+	if team := scrimQueue.RemovePlayer(p); team != nil {
+		for _, member := range team.Players {
+			if member != p {
+				eventLog.Log(pickup.EventDequeue, 0, reason+" from team "+team.Team.Name, member.ID)
+				registry.Release(member.ID)
+				presencePolicy.Cancel(member.ID)
+			}
+		}
+	}
+	pickup.RemoveRole(s, guildID, p.ID, pickup.RoleSearchPair)
+	pickup.RemoveRole(s, guildID, p.ID, pickup.RoleSearchQuad)
+	pickup.RemoveRole(s, guildID, p.ID, pickup.RoleSearchPrivate)
+}
+
+// leaveQueue removes a player from one queue and takes away its searching role, without changing the player's state.
+func leaveQueue(s *discordgo.Session, guildID string, p *pickup.Player, queueType int, reason string) {
+	q, ok := searchQueues[queueType]
+	if !ok || !q.Contains(p) {
+		return
+	}
+
+	eventLog.Log(pickup.EventDequeue, 0, queueName(queueType)+": "+reason, p.ID)
+	q.Remove(p)
+	pickup.RemoveRole(s, guildID, p.ID, pickup.SearchRole(queueType))
+}
+
+// queuesContaining gets the types of the queues a player is searching in.
+func queuesContaining(p *pickup.Player) []int {
+	var queueTypes []int
+	for queueType, q := range searchQueues {
+		if q.Contains(p) {
+			queueTypes = append(queueTypes, queueType)
+		}
+	}
+	return queueTypes
+}
+
+// leaveRoom removes a player from a room and takes away their access to its channels.
+func leaveRoom(s *discordgo.Session, guildID string, room *pickup.Room, p *pickup.Player) {
+	eventLog.Log(pickup.EventLeave, room.ID, "", p.ID)
+	room.RemovePlayer(p)
+	registry.Release(p.ID)
+	pickup.RemoveRole(s, guildID, p.ID, pickup.RoleInProgress)
+	room.RevokeAccess(s, p)
+}
+
+// finishRoom releases the players left in a room and closes the room after the cleanup delay.
+// message : Shown in the room's text channel
+// reason  : Why the room was closed, recorded in the event log
+func finishRoom(s *discordgo.Session, guildID string, room *pickup.Room, message string, reason string) {
+	for _, p := range room.PlayerList() {
+		if registry.Transition(p.ID, pickup.StateInMatch, pickup.StateIdle) == nil {
+			pickup.RemoveRole(s, guildID, p.ID, pickup.RoleInProgress)
+		}
+	}
+
+	if room.StartCleanup() {
+		go func() {
+			room.Cleanup(s, message, currentConfig().CleanupDelay)
+			removeRoom(room)
+			eventLog.Log(pickup.EventCleanup, room.ID, reason)
+		}()
+	}
+}
+
+// closeRoom immediately deletes a room and releases the players that were in it.
+func closeRoom(s *discordgo.Session, guildID string, room *pickup.Room) {
+	for _, p := range room.PlayerList() {
+		if registry.Transition(p.ID, pickup.StateInMatch, pickup.StateIdle) == nil {
+			pickup.RemoveRole(s, guildID, p.ID, pickup.RoleInProgress)
+		}
+	}
+	room.Close(s)
+	removeRoom(room)
+	eventLog.Log(pickup.EventCleanup, room.ID, "room closed immediately")
+}
+
+// addRoom adds a room to the list of active rooms.
+func addRoom(room *pickup.Room) {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	rooms = append(rooms, room)
+	recordRoomCreated(room)
+}
+
+// removeRoom removes a room from the list of active rooms.
+func removeRoom(room *pickup.Room) {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	for i, r := range rooms {
+		if r == room {
+			copy(rooms[i:], rooms[i+1:])
+			rooms[len(rooms)-1] = nil
+			rooms = rooms[:len(rooms)-1]
+			recordRoomClosed(room)
+			return
+		}
+	}
+}
+
+// activeRooms returns a copy of the list of active rooms.
+func activeRooms() []*pickup.Room {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	return append([]*pickup.Room(nil), rooms...)
+}
+
+// findRoomByChannel finds an active room by its text channel.
+func findRoomByChannel(channelID string) *pickup.Room {
+	for _, room := range activeRooms() {
+		if room.TextChannel == channelID {
+			return room
+		}
+	}
+	return nil
+}
+
+// findRoom finds an active room by its ID.
+func findRoom(id int) *pickup.Room {
+	for _, room := range activeRooms() {
+		if room.ID == id {
+			return room
+		}
 	}
+	return nil
 }
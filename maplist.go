@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// mapListCommand shows or rerolls the set of games for a private room. It must be used in the room's text channel.
+func mapListCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	room := findRoomByChannel(m.ChannelID)
+	if room == nil || room.QueueType != pickup.Private {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Map lists can only be used in a private battle room.", m.Author.ID))
+		return
+	}
+
+	if len(input) > 1 && input[1] == "regen" {
+		p, ok := registry.Get(m.Author.ID)
+		if !ok || !room.PlayerInRoom(p) {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only players in the room can reroll the map list.", m.Author.ID))
+			return
+		}
+
+		maps, err := currentConfig().mapPool.Generate()
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not generate a map list.", m.Author.ID))
+			return
+		}
+		room.SetMaps(maps)
+		eventLog.Log(pickup.EventMapList, room.ID, "rerolled map list", m.Author.ID)
+	}
+
+	maps := room.Maps()
+	if maps == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: This room has no map list. Type \"!maplist regen\" to create one."), m.Author.ID))
+		return
+	}
+	s.ChannelMessageSend(m.ChannelID, pickup.FormatMapList(maps))
+}
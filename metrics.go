@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	queueWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "squidup_queue_wait_seconds",
+		Help:    "How long players searched before a room was created for them.",
+		Buckets: []float64{30, 60, 120, 300, 600, 900, 1800, 3600},
+	}, []string{"queue"})
+	roomLifetimeSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "squidup_room_lifetime_seconds",
+		Help:    "How long rooms stayed open before they were closed.",
+		Buckets: []float64{300, 600, 1200, 1800, 2700, 3600, 5400, 7200},
+	}, []string{"queue"})
+	roomsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "squidup_rooms_created_total",
+		Help: "Rooms created for matches.",
+	}, []string{"queue"})
+	roomsClosed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "squidup_rooms_closed_total",
+		Help: "Rooms closed after their match.",
+	}, []string{"queue"})
+	discordErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "squidup_discord_api_errors_total",
+		Help: "Discord API requests that failed or returned an error status.",
+	}, []string{"endpoint"})
+	presenceRemovals = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "squidup_presence_removals_total",
+		Help: "Players removed from the queues because of their Discord status.",
+	}, []string{"status"})
+)
+
+func init() {
+	for queueType, q := range searchQueues {
+		q := q
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "squidup_queue_length",
+			Help:        "Players searching in a queue.",
+			ConstLabels: prometheus.Labels{"queue": queueName(queueType)},
+		}, func() float64 { return float64(q.Len()) })
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "squidup_active_rooms",
+		Help: "Rooms that are currently open.",
+	}, func() float64 { return float64(len(activeRooms())) })
+}
+
+// serveMetrics serves the Prometheus metrics at /metrics.
+func serveMetrics(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// recordRoomCreated counts a new room and how long its players searched.
+func recordRoomCreated(room *pickup.Room) {
+	name := queueName(room.QueueType)
+	roomsCreated.WithLabelValues(name).Inc()
+	for _, e := range room.QueueEntries() {
+		queueWaitSeconds.WithLabelValues(name).Observe(room.Created.Sub(e.Joined).Seconds())
+	}
+}
+
+// recordRoomClosed counts a closed room and how long it was open.
+func recordRoomClosed(room *pickup.Room) {
+	name := queueName(room.QueueType)
+	roomsClosed.WithLabelValues(name).Inc()
+	roomLifetimeSeconds.WithLabelValues(name).Observe(time.Since(room.Created).Seconds())
+}
+
+// discordIDRegex matches a Discord snowflake ID.
+var discordIDRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// discordMetricsTransport counts failed Discord API requests by endpoint.
+type discordMetricsTransport struct {
+	next http.RoundTripper
+}
+
+// instrumentDiscord counts the errors of every request the session makes to the Discord API.
+func instrumentDiscord(s *discordgo.Session) {
+	if s.Client == nil {
+		s.Client = &http.Client{}
+	}
+	next := s.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	s.Client.Transport = discordMetricsTransport{next: next}
+}
+
+func (t discordMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 400 {
+		discordErrors.WithLabelValues(req.Method + " " + discordEndpoint(req.URL.Path)).Inc()
+	}
+	return resp, err
+}
+
+// discordEndpoint turns a Discord API path into an endpoint without IDs or emoji,
+// such as /channels/{id}/messages/{id}/reactions/{emoji}/@me.
+func discordEndpoint(path string) string {
+	parts := strings.Split(path, "/")
+	var endpoint []string
+	for i, part := range parts {
+		switch {
+		case part == "" || part == "api" || (i == 2 && strings.HasPrefix(part, "v")):
+			continue
+		case discordIDRegex.MatchString(part):
+			part = "{id}"
+		case i > 0 && parts[i-1] == "reactions":
+			part = "{emoji}"
+		}
+		endpoint = append(endpoint, part)
+	}
+	return "/" + strings.Join(endpoint, "/")
+}
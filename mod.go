@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// moderatorRole is the name of the Discord role allowed to use moderator commands.
+const moderatorRole = "Moderator"
+
+// moderatorCommand handles the "!mod" commands.
+func moderatorCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	guildID := pickup.GetGuildID(s)
+	if !pickup.MemberHasRole(s, guildID, m.Author.ID, moderatorRole) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Only moderators can use \"!mod\" commands."), m.Author.ID))
+		return
+	}
+
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod kick|close|move|clearqueue|rooms|ban|unban|log"), m.Author.ID))
+		return
+	}
+
+	switch input[1] {
+	case "kick":
+		modKick(s, m, guildID, input[2:])
+	case "close":
+		modClose(s, m, guildID, input[2:])
+	case "move":
+		modMove(s, m, guildID, input[2:])
+	case "clearqueue":
+		modClearQueue(s, m, guildID, input[2:])
+	case "rooms":
+		modRooms(s, m, guildID)
+	case "ban":
+		modBan(s, m, guildID, input[2:])
+	case "unban":
+		modUnban(s, m, guildID, input[2:])
+	case "log":
+		modLog(s, m, guildID, input[2:])
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Unknown moderator command \"%s\".", m.Author.ID, input[1]))
+	}
+}
+
+// modKick removes a player from every queue.
+func modKick(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod kick @user"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, args[0]))
+		return
+	}
+
+	p, ok := registry.Get(id)
+	if !ok || registry.State(id) != pickup.StateSearching {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is not in a queue.", m.Author.ID, id))
+		return
+	}
+
+	removeFromQueues(s, guildID, p, "kicked by a moderator")
+	logModAction(s, guildID, m.Author.ID, "kick", 0, "", id)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> has been removed from the queue.", m.Author.ID, id))
+}
+
+// modClose closes a room immediately.
+func modClose(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod close <room>"), m.Author.ID))
+		return
+	}
+
+	room, ok := parseRoom(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Room %s does not exist.", m.Author.ID, args[0]))
+		return
+	}
+
+	closeRoom(s, guildID, room)
+	logModAction(s, guildID, m.Author.ID, "close", room.ID, "")
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Room %d has been closed.", m.Author.ID, room.ID))
+}
+
+// modMove moves a player into a room, taking them out of any queue or room they are currently in.
+func modMove(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod move @user <room>"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, args[0]))
+		return
+	}
+
+	room, ok := parseRoom(args[1])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Room %s does not exist.", m.Author.ID, args[1]))
+		return
+	}
+
+	if !playerStore.PlayerExists(id) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> needs to be registered before being moved.", m.Author.ID, id))
+		return
+	}
+
+	p := registry.Load(id, playerStore)
+	if room.PlayerInRoom(p) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is already in room %d.", m.Author.ID, id, room.ID))
+		return
+	}
+	if registry.State(id) == pickup.StateReadyCheck {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is being placed in a room. Try again in a moment.", m.Author.ID, id))
+		return
+	}
+
+	if registry.State(id) == pickup.StateSearching {
+		removeFromQueues(s, guildID, p, "moved to a room by a moderator")
+	}
+	for _, r := range activeRooms() {
+		if r.PlayerInRoom(p) {
+			leaveRoom(s, guildID, r, p)
+		}
+	}
+
+	registry.Release(id)
+	if err := registry.Transition(id, pickup.StateIdle, pickup.StateInMatch); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not move <@%s>: %v", m.Author.ID, id, err))
+		return
+	}
+	room.AddPlayer(p)
+	room.GrantAccess(s, p)
+	pickup.AddRole(s, guildID, p.ID, pickup.RoleInProgress)
+
+	logModAction(s, guildID, m.Author.ID, "move", room.ID, "", id)
+	s.ChannelMessageSend(room.TextChannel, fmt.Sprintf("<@%s> has been moved into this room by a moderator. Friend code: %s", id, p.FriendCode))
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> has been moved to room %d.", m.Author.ID, id, room.ID))
+}
+
+// modClearQueue removes every player from a queue.
+func modClearQueue(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod clearqueue pair|quad|private|scrim"), m.Author.ID))
+		return
+	}
+
+	if args[0] == "scrim" {
+		var ids []string
+		for _, team := range scrimQueue.Clear() {
+			for _, p := range team.Players {
+				registry.Release(p.ID)
+				presencePolicy.Cancel(p.ID)
+				ids = append(ids, p.ID)
+			}
+		}
+		logModAction(s, guildID, m.Author.ID, "clearqueue", 0, args[0], ids...)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Removed %d players from the scrim queue.", m.Author.ID, len(ids)))
+		return
+	}
+
+	var q *pickup.Queue
+	var role string
+	switch args[0] {
+	case "pair":
+		q, role = &pairQueue, pickup.RoleSearchPair
+	case "quad":
+		q, role = &quadQueue, pickup.RoleSearchQuad
+	case "private":
+		q, role = &privateQueue, pickup.RoleSearchPrivate
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid queue. Use pair, quad, private, or scrim.", m.Author.ID, args[0]))
+		return
+	}
+
+	// Players who are still searching in other queues keep searching
+	cleared := q.Clear()
+	for _, p := range cleared {
+		if len(queuesContaining(p)) == 0 {
+			registry.Release(p.ID)
+			presencePolicy.Cancel(p.ID)
+		}
+		pickup.RemoveRole(s, guildID, p.ID, role)
+	}
+
+	var ids []string
+	for _, p := range cleared {
+		ids = append(ids, p.ID)
+	}
+	logModAction(s, guildID, m.Author.ID, "clearqueue", 0, args[0], ids...)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Removed %d players from the %s queue.", m.Author.ID, len(cleared), args[0]))
+}
+
+// modRooms lists the active rooms with their players and age.
+func modRooms(s *discordgo.Session, m *discordgo.MessageCreate, guildID string) {
+	active := activeRooms()
+	if len(active) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There are no active rooms.", m.Author.ID))
+		return
+	}
+
+	msg := "Active rooms:"
+	for _, room := range active {
+		var names []string
+		for _, p := range room.PlayerList() {
+			names = append(names, pickup.DisplayName(s, guildID, p.ID))
+		}
+		age := time.Since(room.Created).Truncate(time.Minute)
+		msg += fmt.Sprintf("\nRoom %d (%s, %s old): %s", room.ID, queueName(room.QueueType), age, strings.Join(names, ", "))
+	}
+
+	logModAction(s, guildID, m.Author.ID, "rooms", 0, "")
+	s.ChannelMessageSend(m.ChannelID, msg)
+}
+
+// modBan bans a player from the queues for a period of time.
+func modBan(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod ban @user <duration> [reason]"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, args[0]))
+		return
+	}
+
+	duration, err := parseBanDuration(args[1])
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid duration. Use a number followed by d, h, or m, such as 7d.", m.Author.ID, args[1]))
+		return
+	}
+
+	reason := strings.Join(args[2:], " ")
+	if reason == "" {
+		reason = "No reason given"
+	}
+
+	ban := pickup.Ban{
+		PlayerID:    id,
+		ModeratorID: m.Author.ID,
+		Reason:      reason,
+		Expires:     time.Now().Add(duration),
+	}
+	banStore.Ban(ban)
+
+	if p, ok := registry.Get(id); ok && registry.State(id) == pickup.StateSearching {
+		removeFromQueues(s, guildID, p, "banned by a moderator")
+	}
+
+	logModAction(s, guildID, m.Author.ID, "ban", 0, fmt.Sprintf("%s, %s", duration, reason), id)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is banned from matchmaking %s", m.Author.ID, id, banDescription(&ban)))
+}
+
+// modUnban lifts a player's ban.
+func modUnban(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod unban @user"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, args[0]))
+		return
+	}
+
+	banStore.Unban(id)
+	logModAction(s, guildID, m.Author.ID, "unban", 0, "", id)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is no longer banned from matchmaking.", m.Author.ID, id))
+}
+
+// modLog shows the most recent events concerning a player.
+func modLog(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, args []string) {
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !mod log @user"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, args[0]))
+		return
+	}
+
+	entries := eventLog.Store.GetPlayerEntries(id, 20)
+	logModAction(s, guildID, m.Author.ID, "log", 0, "", id)
+
+	if len(entries) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There are no events for that player.", m.Author.ID))
+		return
+	}
+
+	msg := "Recent events:"
+	for _, entry := range entries {
+		msg += fmt.Sprintf("\n%s `%s`", entry.Time.Format("Jan 2 15:04"), entry.Type)
+		if entry.RoomID != 0 {
+			msg += fmt.Sprintf(" room %d", entry.RoomID)
+		}
+		if entry.Details != "" {
+			msg += ": " + entry.Details
+		}
+	}
+	s.ChannelMessageSend(m.ChannelID, msg)
+}
+
+// logModAction records a moderator command in the event log.
+func logModAction(s *discordgo.Session, guildID string, moderatorID string, action string, roomID int, details string, playerIDs ...string) {
+	msg := fmt.Sprintf("%s used %s", pickup.DisplayName(s, guildID, moderatorID), action)
+	if details != "" {
+		msg += " (" + details + ")"
+	}
+	eventLog.Log(pickup.EventModerator, roomID, msg, playerIDs...)
+}
+
+// parseBanDuration parses a duration such as "7d", "12h" or "30m".
+func parseBanDuration(arg string) (time.Duration, error) {
+	if strings.HasSuffix(arg, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid number of days: %s", arg)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(arg)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration must be positive: %s", arg)
+	}
+	return duration, nil
+}
+
+// parseMention gets the user ID from a mention, which can be a nickname mention such as "<@!123>".
+func parseMention(mention string) (string, bool) {
+	match := memberRegex.FindStringSubmatch(mention)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// parseRoom finds an active room from its ID.
+func parseRoom(arg string) (*pickup.Room, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return nil, false
+	}
+
+	room := findRoom(id)
+	return room, room != nil
+}
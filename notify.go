@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// almostFullRole is the name of the Discord role that is pinged when a queue is almost full.
+const almostFullRole = "Queue Almost Full"
+
+// almostFullCooldown is how long to wait before notifying about the same queue again.
+const almostFullCooldown = 10 * time.Minute
+
+var lastAlmostFull = make(map[int]time.Time)
+var almostFullMutex sync.Mutex
+
+// notifyCommand handles the "!notify" commands for changing notification settings.
+func notifyCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can change notifications."), m.Author.ID))
+		return
+	}
+	player := registry.Load(m.Author.ID, playerStore)
+	settings := player.Notify()
+
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s", m.Author.ID, describeNotify(settings)))
+		return
+	}
+
+	switch {
+	case input[1] == "match" && len(input) > 2 && (input[2] == "on" || input[2] == "off"):
+		settings.Match = input[2] == "on"
+	case input[1] == "ping" && len(input) > 2 && (input[2] == "on" || input[2] == "off"):
+		roleIDs := pickup.RoleIDs(s, pickup.GetGuildID(s), almostFullRole)
+		if len(roleIDs) == 0 {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: This server does not have a \"%s\" role.", m.Author.ID, almostFullRole))
+			return
+		}
+		settings.Ping = input[2] == "on"
+		if settings.Ping {
+			pickup.AddRole(s, pickup.GetGuildID(s), m.Author.ID, roleIDs[0])
+		} else {
+			pickup.RemoveRole(s, pickup.GetGuildID(s), m.Author.ID, roleIDs[0])
+		}
+	case (input[1] == "follow" || input[1] == "unfollow") && len(input) > 2:
+		queueType, ok := parseQueueType(input[2])
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a queue.", m.Author.ID, input[2]))
+			return
+		}
+		var follow []int
+		for _, t := range settings.Follow {
+			if t != queueType {
+				follow = append(follow, t)
+			}
+		}
+		if input[1] == "follow" {
+			follow = append(follow, queueType)
+		}
+		settings.Follow = follow
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !notify [match on|off] [ping on|off] [follow|unfollow pair|quad|private]"), m.Author.ID))
+		return
+	}
+
+	playerStore.SetNotifySettings(m.Author.ID, settings)
+	player.SetNotify(settings)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s", m.Author.ID, describeNotify(settings)))
+}
+
+// describeNotify describes a player's notification settings.
+func describeNotify(settings pickup.NotifySettings) string {
+	onOff := map[bool]string{true: "on", false: "off"}
+	follows := "none"
+	if len(settings.Follow) > 0 {
+		var names []string
+		for _, t := range settings.Follow {
+			names = append(names, queueName(t))
+		}
+		follows = strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("Match found DMs: %s. Almost full pings: %s. Followed queues: %s.", onOff[settings.Match], onOff[settings.Ping], follows)
+}
+
+// notifyMatchFound sends a DM to the players in a new room who asked to be told when a match is found.
+func notifyMatchFound(s *discordgo.Session, room *pickup.Room) {
+	for _, p := range room.PlayerList() {
+		if p.Notify().Match {
+			sendDM(s, p.ID, fmt.Sprintf("A match has been found! Head to <#%s>.", room.TextChannel))
+		}
+	}
+}
+
+// queueAlmostFull pings the almost full role and sends a DM to the players following a queue that needs one more player.
+func queueAlmostFull(s *discordgo.Session, queueType int) {
+	almostFullMutex.Lock()
+	if time.Since(lastAlmostFull[queueType]) < almostFullCooldown {
+		almostFullMutex.Unlock()
+		return
+	}
+	lastAlmostFull[queueType] = time.Now()
+	almostFullMutex.Unlock()
+
+	msg := fmt.Sprintf(pickup.Commands("The %s queue needs 1 more player! Type \"!%s\" in <#%s> to join."), queueName(queueType), queueName(queueType), pickup.SearchChannelID)
+	if roleIDs := pickup.RoleIDs(s, pickup.GetGuildID(s), almostFullRole); len(roleIDs) > 0 {
+		s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("<@&%s> %s", roleIDs[0], msg))
+	}
+
+	q := searchQueues[queueType]
+	for _, id := range playerStore.GetFollowers(queueType) {
+		// Players who are already searching or playing do not need to be told
+		if registry.State(id) != pickup.StateIdle {
+			continue
+		}
+		if p, ok := registry.Get(id); ok && q.Contains(p) {
+			continue
+		}
+		sendDM(s, id, msg)
+	}
+}
+
+// sendDM sends a direct message to a player.
+func sendDM(s *discordgo.Session, playerID string, msg string) error {
+	channel, err := s.UserChannelCreate(playerID)
+	if err != nil {
+		return err
+	}
+	_, err = s.ChannelMessageSend(channel.ID, msg)
+	return err
+}
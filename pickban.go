@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// pickBanCommand starts a pick/ban set in a room. Team rooms use the team captains, other rooms must mention two captains.
+func pickBanCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	room := findRoomByChannel(m.ChannelID)
+	if room == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: A pick/ban set can only be started in a room.", m.Author.ID))
+		return
+	}
+	if pickBan := room.PickBan(); pickBan != nil && pickBan.State() != pickup.PickBanFinished {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: A set is already being played in this room.", m.Author.ID))
+		return
+	}
+
+	var captains [2]string
+	if len(room.Teams) == 2 {
+		captains = [2]string{room.Teams[0].Team.CaptainID, room.Teams[1].Team.CaptainID}
+	} else {
+		if len(input) < 3 {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !pickban @captain @captain"), m.Author.ID))
+			return
+		}
+		for i, arg := range input[1:3] {
+			id, ok := parseMention(arg)
+			p, registered := registry.Get(id)
+			if !ok || !registered || !room.PlayerInRoom(p) {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not in this room.", m.Author.ID, arg))
+				return
+			}
+			captains[i] = id
+		}
+		if captains[0] == captains[1] {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: The captains must be different players.", m.Author.ID))
+			return
+		}
+	}
+
+	if p, ok := registry.Get(m.Author.ID); !ok || !room.PlayerInRoom(p) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only players in the room can start a set.", m.Author.ID))
+		return
+	}
+
+	pickBan := pickup.NewPickBan(currentConfig().mapPool, captains)
+	room.SetPickBan(pickBan)
+	eventLog.Log(pickup.EventMapList, room.ID, "started a pick/ban set", captains[0], captains[1])
+	if err := pickBan.PostPrompt(s, room.TextChannel); err != nil {
+		room.Logger().Error("Error occurred while posting pick/ban prompt", "err", err)
+	}
+}
+
+// reportPickBanGame records the winner of a game in a pick/ban set and posts the next step.
+// Returns false if the set was not waiting for a result.
+func reportPickBanGame(s *discordgo.Session, m *discordgo.MessageCreate, room *pickup.Room, pickBan *pickup.PickBan, winnerID string) bool {
+	if err := pickBan.Report(winnerID); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not report the result: %s.", m.Author.ID, err))
+		return false
+	}
+	if err := pickBan.PostPrompt(s, room.TextChannel); err != nil {
+		room.Logger().Error("Error occurred while posting pick/ban prompt", "err", err)
+	}
+	return true
+}
+
+// messageReactionAdd is called when a reaction is added to a message.
+// Players confirm they are still searching or join announced queues, and captains strike stages and pick modes, by reacting to the bot's messages.
+func messageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.User.ID {
+		return
+	}
+	if confirmSearching(s, r) || joinFromAnnouncement(s, r) {
+		return
+	}
+
+	room := findRoomByChannel(r.ChannelID)
+	if room == nil {
+		return
+	}
+	pickBan := room.PickBan()
+	if pickBan == nil {
+		return
+	}
+
+	option := pickup.OptionIndex(r.Emoji.Name)
+	if option < 0 {
+		return
+	}
+	if err := pickBan.Choose(r.MessageID, r.UserID, option); err != nil {
+		return
+	}
+	if err := pickBan.PostPrompt(s, room.TextChannel); err != nil {
+		room.Logger().Error("Error occurred while posting pick/ban prompt", "err", err)
+	}
+}
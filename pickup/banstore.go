@@ -0,0 +1,63 @@
+package pickup
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Ban prevents a player from joining matchmaking queues until it expires.
+type Ban struct {
+	PlayerID    string
+	ModeratorID string
+	Reason      string
+	Expires     time.Time
+}
+
+// BanStore is an interface for structs that can store queue bans
+type BanStore interface {
+	Ban(ban Ban)
+	Unban(id string)
+	GetBan(id string) *Ban
+}
+
+// SQLiteBanStore implements BanStore and uses a SQLite database to store bans
+type SQLiteBanStore struct {
+	DB *sql.DB
+}
+
+func (bs SQLiteBanStore) Ban(ban Ban) {
+	_, err := bs.DB.Exec("INSERT OR REPLACE INTO Bans (DiscordID, ModeratorID, Reason, Expires) VALUES (?, ?, ?, ?)",
+		ban.PlayerID, ban.ModeratorID, ban.Reason, ban.Expires.Unix())
+	if err != nil {
+		slog.Error("Error occurred while banning player", "player", ban.PlayerID, "err", err)
+	}
+}
+
+func (bs SQLiteBanStore) Unban(id string) {
+	_, err := bs.DB.Exec("DELETE FROM Bans WHERE DiscordID = ?", id)
+	if err != nil {
+		slog.Error("Error occurred while unbanning player", "player", id, "err", err)
+	}
+}
+
+// GetBan returns the player's ban, or nil if the player is not banned or the ban has expired.
+func (bs SQLiteBanStore) GetBan(id string) *Ban {
+	var expires int64
+	ban := new(Ban)
+	ban.PlayerID = id
+	err := bs.DB.QueryRow("SELECT ModeratorID, Reason, Expires FROM Bans WHERE DiscordID = ?", id).Scan(&ban.ModeratorID, &ban.Reason, &expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Error occurred while getting ban", "player", id, "err", err)
+		}
+		return nil
+	}
+
+	ban.Expires = time.Unix(expires, 0)
+	if time.Now().After(ban.Expires) {
+		bs.Unban(id)
+		return nil
+	}
+	return ban
+}
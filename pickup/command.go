@@ -0,0 +1,50 @@
+package pickup
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var commandPrefix = "!"
+var commandPrefixMutex sync.RWMutex
+
+// commandRegex matches the start of a command written in a message, such as "!leave".
+var commandRegex = regexp.MustCompile(`(^|["\s])!([a-z%])`)
+
+// CommandPrefix gets the text that starts every command.
+func CommandPrefix() string {
+	commandPrefixMutex.RLock()
+	defer commandPrefixMutex.RUnlock()
+
+	return commandPrefix
+}
+
+// SetCommandPrefix changes the text that starts every command.
+func SetCommandPrefix(prefix string) {
+	commandPrefixMutex.Lock()
+	defer commandPrefixMutex.Unlock()
+
+	commandPrefix = prefix
+}
+
+// Commands rewrites the commands in a message, which are written starting with "!", to start with the command prefix.
+func Commands(msg string) string {
+	prefix := CommandPrefix()
+	if prefix == "!" {
+		return msg
+	}
+	return commandRegex.ReplaceAllStringFunc(msg, func(match string) string {
+		return strings.Replace(match, "!", prefix, 1)
+	})
+}
+
+// ParseCommand rewrites a message that starts with the command prefix to start with "!" instead,
+// so commands are handled the same way whatever the prefix is. Returns false if the message is not a command.
+func ParseCommand(content string) (string, bool) {
+	prefix := CommandPrefix()
+	if !strings.HasPrefix(content, prefix) {
+		return content, false
+	}
+	return "!" + strings.TrimPrefix(content, prefix), true
+}
@@ -7,17 +7,20 @@ const (
 	Quad
 	// Private is an identifier for queuing for private battles
 	Private
+	// Scrim is an identifier for queuing a team of 4 against another team of 4
+	Scrim
 )
 
-const (
+// The IDs of the guild's roles and channels. They are set from the configuration at startup, before the bot connects.
+var (
 	// RoleSearchPair is the Discord role for "Searching for Pair"
-	RoleSearchPair = "374995164222980097"
+	RoleSearchPair string
 	// RoleSearchQuad is the Discord role for "Searching for Quad"
-	RoleSearchQuad = "380166544560357377"
+	RoleSearchQuad string
 	// RoleSearchPrivate is the Discord role for "Searching for Private"
-	RoleSearchPrivate = "380166602563518474"
+	RoleSearchPrivate string
 	// RoleInProgress is the Discord role for "In Progress"
-	RoleInProgress = "374995237342281732"
+	RoleInProgress string
 	// SearchChannelID is the ID for the channel where the search commands are used
-	SearchChannelID = "374996197561073665"
+	SearchChannelID string
 )
@@ -2,38 +2,133 @@ package pickup
 
 import (
 	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
+// roleCacheDuration is how long a guild's roles are cached before they are requested again.
+const roleCacheDuration = 10 * time.Minute
+
+// PermissionView is the permission to view a channel.
+const PermissionView = 1024
+
+// PermissionOverwrite is a permission overwrite set on a channel when it is created.
+type PermissionOverwrite struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Allow int    `json:"allow"`
+	Deny  int    `json:"deny"`
+}
+
+type cachedRoles struct {
+	roles   []*discordgo.Role
+	expires time.Time
+}
+
+var roleCache = make(map[string]cachedRoles)
+var roleCacheMutex sync.Mutex
+
+var guildLocks = make(map[string]*sync.Mutex)
+var guildLocksMutex sync.Mutex
+
 // GetGuildID gets the guild ID from the session.
 func GetGuildID(s *discordgo.Session) string {
 	return s.State.Guilds[0].ID
 }
 
-// SetRolePermission sets the permission of a role in a channel.
-func SetRolePermission(s *discordgo.Session, channelID string, roleName string, allow int, deny int) {
-	guildID := GetGuildID(s)
-	roles, _ := s.GuildRoles(guildID)
+// guildLock gets the mutex used to serialize channel changes within a guild.
+func guildLock(guildID string) *sync.Mutex {
+	guildLocksMutex.Lock()
+	defer guildLocksMutex.Unlock()
+
+	lock, ok := guildLocks[guildID]
+	if !ok {
+		lock = new(sync.Mutex)
+		guildLocks[guildID] = lock
+	}
+	return lock
+}
+
+// GuildRoles gets the roles of a guild, using a cached copy if one was requested recently.
+func GuildRoles(s *discordgo.Session, guildID string) ([]*discordgo.Role, error) {
+	roleCacheMutex.Lock()
+	defer roleCacheMutex.Unlock()
+
+	if cached, ok := roleCache[guildID]; ok && time.Now().Before(cached.expires) {
+		return cached.roles, nil
+	}
+
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	roleCache[guildID] = cachedRoles{roles: roles, expires: time.Now().Add(roleCacheDuration)}
+	return roles, nil
+}
+
+// InvalidateRoleCache discards the cached roles of a guild. Call this when a guild's roles change.
+func InvalidateRoleCache(guildID string) {
+	roleCacheMutex.Lock()
+	defer roleCacheMutex.Unlock()
+
+	delete(roleCache, guildID)
+}
+
+// RoleIDs gets the IDs of the roles in a guild with the given name.
+func RoleIDs(s *discordgo.Session, guildID string, roleName string) []string {
+	var ids []string
+	roles, _ := GuildRoles(s, guildID)
 	for _, role := range roles {
 		if role.Name == roleName {
-			s.ChannelPermissionSet(channelID, role.ID, "role", allow, deny)
+			ids = append(ids, role.ID)
+		}
+	}
+	return ids
+}
+
+// MemberHasRole checks if a member of the guild has a role with the given name.
+func MemberHasRole(s *discordgo.Session, guildID string, userID string, roleName string) bool {
+	member, err := s.GuildMember(guildID, userID)
+	if err != nil {
+		return false
+	}
+
+	for _, roleID := range RoleIDs(s, guildID, roleName) {
+		for _, id := range member.Roles {
+			if id == roleID {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// DisplayName gets a member's username without mentioning them.
+func DisplayName(s *discordgo.Session, guildID string, userID string) string {
+	member, err := s.State.Member(guildID, userID)
+	if err != nil || member.User == nil {
+		return userID
+	}
+	return member.User.Username
 }
 
 // GuildChannelCreateWithParentID creates a new channel in a given guild under a given parentID
-// guildID   : The ID of a Guild
-// name      : Name of the channel (2-100 chars length)
-// ctype     : Type of the channel (voice or text)
-// parentID  : The ID of the parent
-func GuildChannelCreateWithParentID(s *discordgo.Session, guildID, name, ctype, parentID string) (st *discordgo.Channel, err error) {
+// guildID    : The ID of a Guild
+// name       : Name of the channel (2-100 chars length)
+// ctype      : Type of the channel (voice or text)
+// parentID   : The ID of the parent
+// overwrites : Permission overwrites to create the channel with
+func GuildChannelCreateWithParentID(s *discordgo.Session, guildID, name, ctype, parentID string, overwrites []*PermissionOverwrite) (st *discordgo.Channel, err error) {
 
 	data := struct {
-		Name     string `json:"name"`
-		Type     string `json:"type"`
-		ParentID string `json:"parent_id"`
-	}{name, ctype, parentID}
+		Name                 string                 `json:"name"`
+		Type                 string                 `json:"type"`
+		ParentID             string                 `json:"parent_id"`
+		PermissionOverwrites []*PermissionOverwrite `json:"permission_overwrites,omitempty"`
+	}{name, ctype, parentID, overwrites}
 
 	body, err := s.RequestWithBucketID("POST", discordgo.EndpointGuildChannels(guildID), data, discordgo.EndpointGuildChannels(guildID))
 	if err != nil {
@@ -45,14 +140,16 @@ func GuildChannelCreateWithParentID(s *discordgo.Session, guildID, name, ctype,
 }
 
 // GuildChannelCreateCategory creates a new category in a given guild under a given parentID
-// guildID   : The ID of a Guild
-// name      : Name of the category (2-100 chars length)
-func GuildChannelCreateCategory(s *discordgo.Session, guildID, name string) (st *discordgo.Channel, err error) {
+// guildID    : The ID of a Guild
+// name       : Name of the category (2-100 chars length)
+// overwrites : Permission overwrites to create the category with
+func GuildChannelCreateCategory(s *discordgo.Session, guildID, name string, overwrites []*PermissionOverwrite) (st *discordgo.Channel, err error) {
 
 	data := struct {
-		Name string `json:"name"`
-		Type int    `json:"type"`
-	}{name, 4}
+		Name                 string                 `json:"name"`
+		Type                 int                    `json:"type"`
+		PermissionOverwrites []*PermissionOverwrite `json:"permission_overwrites,omitempty"`
+	}{name, 4, overwrites}
 
 	body, err := s.RequestWithBucketID("POST", discordgo.EndpointGuildChannels(guildID), data, discordgo.EndpointGuildChannels(guildID))
 	if err != nil {
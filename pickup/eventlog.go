@@ -0,0 +1,286 @@
+package pickup
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// EventRegister is logged when a player registers or updates their friend code
+	EventRegister = "register"
+	// EventEnqueue is logged when a player joins a queue
+	EventEnqueue = "enqueue"
+	// EventDequeue is logged when a player is removed from a queue without a match being found
+	EventDequeue = "dequeue"
+	// EventRoomCreated is logged when a room is created for a match
+	EventRoomCreated = "room_created"
+	// EventRoomFailed is logged when a room's channels could not be created
+	EventRoomFailed = "room_failed"
+	// EventLeave is logged when a player leaves a room
+	EventLeave = "leave"
+	// EventTeam is logged when a team is created or disbanded, or its members change
+	EventTeam = "team"
+	// EventResult is logged when a team reports the result of a game
+	EventResult = "result"
+	// EventMapList is logged when a room's map list is rerolled or a pick/ban set is started
+	EventMapList = "maplist"
+	// EventCleanup is logged when a room's channels are deleted
+	EventCleanup = "cleanup"
+	// EventModerator is logged when a moderator uses a moderator command
+	EventModerator = "moderator"
+)
+
+// LogEntry is a single event concerning a player.
+type LogEntry struct {
+	Time     time.Time
+	Type     string
+	PlayerID string
+	RoomID   int
+	Details  string
+}
+
+// EventSummary is a single event with every player it concerns.
+type EventSummary struct {
+	Time      time.Time
+	Type      string
+	RoomID    int
+	Details   string
+	PlayerIDs []string
+}
+
+// PlayerCount is how many times an event was logged for a player.
+type PlayerCount struct {
+	PlayerID string
+	Count    int
+}
+
+// EventStore is an interface for structs that can store log entries
+type EventStore interface {
+	AddEvent(entries []LogEntry)
+	GetPlayerEntries(id string, limit int) []LogEntry
+	GetRecentEvents(eventTypes []string, limit int) []EventSummary
+	GetTopPlayers(eventType string, limit int) []PlayerCount
+}
+
+// SQLiteEventStore implements EventStore and uses a SQLite database to store log entries
+type SQLiteEventStore struct {
+	DB *sql.DB
+}
+
+// AddEvent stores the entries of a single event together. Every entry is tagged with the row ID of the first,
+// so the event can be told apart from others logged at the same time.
+func (es SQLiteEventStore) AddEvent(entries []LogEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := es.addEvent(entries); err != nil {
+		slog.Error("Error occurred while storing event", "type", entries[0].Type, "room", entries[0].RoomID, "err", err)
+	}
+}
+
+func (es SQLiteEventStore) addEvent(entries []LogEntry) error {
+	tx, err := es.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var eventID int64
+	for _, entry := range entries {
+		result, err := tx.Exec("INSERT INTO EventLog (Time, Type, DiscordID, RoomID, Details, EventID) VALUES (?, ?, ?, ?, ?, ?)",
+			entry.Time.Unix(), entry.Type, entry.PlayerID, entry.RoomID, entry.Details, eventID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if eventID != 0 {
+			continue
+		}
+
+		if eventID, err = result.LastInsertId(); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec("UPDATE EventLog SET EventID = ? WHERE ID = ?", eventID, eventID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetPlayerEntries returns the most recent entries concerning a player, newest first.
+func (es SQLiteEventStore) GetPlayerEntries(id string, limit int) []LogEntry {
+	var entries []LogEntry
+	rows, err := es.DB.Query("SELECT Time, Type, DiscordID, RoomID, Details FROM EventLog WHERE DiscordID = ? ORDER BY ID DESC LIMIT ?", id, limit)
+	if err != nil {
+		slog.Error("Error occurred while getting player events", "player", id, "err", err)
+		return entries
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry LogEntry
+		var t int64
+		rows.Scan(&t, &entry.Type, &entry.PlayerID, &entry.RoomID, &entry.Details)
+		entry.Time = time.Unix(t, 0)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// GetRecentEvents returns the most recent events of the given types, newest first.
+func (es SQLiteEventStore) GetRecentEvents(eventTypes []string, limit int) []EventSummary {
+	var events []EventSummary
+	if len(eventTypes) == 0 {
+		return events
+	}
+
+	args := make([]interface{}, 0, len(eventTypes)+1)
+	for _, t := range eventTypes {
+		args = append(args, t)
+	}
+	args = append(args, limit)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(eventTypes)), ", ")
+
+	// An event is stored once for each player it concerns, so entries logged together are grouped back into one event
+	rows, err := es.DB.Query(`SELECT MIN(Time), MIN(Type), MIN(RoomID), MIN(Details), GROUP_CONCAT(DiscordID) FROM EventLog
+		WHERE Type IN (`+placeholders+`) GROUP BY EventID ORDER BY MAX(ID) DESC LIMIT ?`, args...)
+	if err != nil {
+		slog.Error("Error occurred while getting recent events", "types", eventTypes, "err", err)
+		return events
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event EventSummary
+		var t int64
+		var ids sql.NullString
+		rows.Scan(&t, &event.Type, &event.RoomID, &event.Details, &ids)
+		event.Time = time.Unix(t, 0)
+		for _, id := range strings.Split(ids.String, ",") {
+			if id != "" {
+				event.PlayerIDs = append(event.PlayerIDs, id)
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// GetTopPlayers returns the players an event was logged for the most, most first.
+func (es SQLiteEventStore) GetTopPlayers(eventType string, limit int) []PlayerCount {
+	var counts []PlayerCount
+	rows, err := es.DB.Query(`SELECT DiscordID, COUNT(*) AS Count FROM EventLog WHERE Type = ? AND DiscordID != ''
+		GROUP BY DiscordID ORDER BY Count DESC LIMIT ?`, eventType, limit)
+	if err != nil {
+		slog.Error("Error occurred while getting top players", "type", eventType, "err", err)
+		return counts
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var count PlayerCount
+		rows.Scan(&count.PlayerID, &count.Count)
+		counts = append(counts, count)
+	}
+	return counts
+}
+
+// eventPostBacklog is how many events can wait to be posted to the log channel before new ones are dropped.
+const eventPostBacklog = 100
+
+// EventLog records events to a store and posts them to a Discord channel.
+type EventLog struct {
+	Store     EventStore
+	Session   *discordgo.Session
+	ChannelID string
+
+	posts     chan eventPost
+	startPost sync.Once
+}
+
+// eventPost is an event waiting to be posted to the log channel.
+type eventPost struct {
+	eventType string
+	roomID    int
+	details   string
+	playerIDs []string
+}
+
+// Log records an event and writes it to the log. One entry is stored for each player the event concerns.
+// eventType : Type of event. See the Event constants for values
+// roomID    : ID of the room the event concerns, or 0 if there is none
+// details   : Description of the event
+// playerIDs : Discord IDs of the players the event concerns
+func (el *EventLog) Log(eventType string, roomID int, details string, playerIDs ...string) {
+	attrs := []interface{}{"type", eventType}
+	if roomID != 0 {
+		attrs = append(attrs, "room", roomID)
+	}
+	if len(playerIDs) > 0 {
+		attrs = append(attrs, "players", playerIDs)
+	}
+	if details != "" {
+		attrs = append(attrs, "details", details)
+	}
+	slog.Info("Event", attrs...)
+
+	now := time.Now()
+	var entries []LogEntry
+	if len(playerIDs) == 0 {
+		entries = append(entries, LogEntry{Time: now, Type: eventType, RoomID: roomID, Details: details})
+	}
+	for _, id := range playerIDs {
+		entries = append(entries, LogEntry{Time: now, Type: eventType, PlayerID: id, RoomID: roomID, Details: details})
+	}
+	el.Store.AddEvent(entries)
+
+	if el.ChannelID == "" || el.Session == nil {
+		return
+	}
+
+	// Looking up names and posting can be slow, so events are posted in order by a single goroutine
+	el.startPost.Do(func() {
+		el.posts = make(chan eventPost, eventPostBacklog)
+		go func() {
+			for post := range el.posts {
+				el.post(post.eventType, post.roomID, post.details, post.playerIDs)
+			}
+		}()
+	})
+	select {
+	case el.posts <- eventPost{eventType, roomID, details, playerIDs}:
+	default:
+		slog.Warn("Event log channel is backed up, not posting event", "type", eventType, "room", roomID)
+	}
+}
+
+// post writes an event to the log channel.
+func (el *EventLog) post(eventType string, roomID int, details string, playerIDs []string) {
+	msg := fmt.Sprintf("`%s`", eventType)
+	if roomID != 0 {
+		msg += fmt.Sprintf(" room %d", roomID)
+	}
+	if len(playerIDs) > 0 {
+		guildID := GetGuildID(el.Session)
+		var names []string
+		for _, id := range playerIDs {
+			names = append(names, DisplayName(el.Session, guildID, id))
+		}
+		msg += " [" + strings.Join(names, ", ") + "]"
+	}
+	if details != "" {
+		msg += ": " + details
+	}
+
+	_, err := el.Session.ChannelMessageSend(el.ChannelID, msg)
+	if err != nil {
+		slog.Error("Error occurred while posting event", "type", eventType, "room", roomID, "err", err)
+	}
+}
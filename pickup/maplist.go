@@ -0,0 +1,167 @@
+package pickup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mapListAttempts is how many times to try generating a set before giving up on a pool.
+const mapListAttempts = 20
+
+// MapPick is a game in a set, played on a stage in a mode.
+type MapPick struct {
+	Mode  string
+	Stage string
+}
+
+// ModePool is the stages that can be played in a mode.
+type ModePool struct {
+	Name   string   `json:"name" yaml:"name"`
+	Stages []string `json:"stages" yaml:"stages"`
+}
+
+// MapPool is the modes and stages that sets are generated from.
+// Games in a set go through the modes in order.
+type MapPool struct {
+	BestOf int        `json:"bestOf" yaml:"bestOf"`
+	Modes  []ModePool `json:"modes" yaml:"modes"`
+}
+
+var splatoonStages = []string{
+	"The Reef", "Musselforge Fitness", "Starfish Mainstage", "Humpback Pump Track", "Inkblot Art Academy",
+	"Sturgeon Shipyard", "Moray Towers", "Port Mackerel", "Manta Maria", "Kelp Dome",
+	"Snapper Canal", "Blackbelly Skatepark", "MakoMart", "Walleye Warehouse", "Shellendorf Institute",
+	"Arowana Mall", "Goby Arena", "Piranha Pit", "Camp Triggerfish", "Wahoo World",
+}
+
+// LeagueModes are the ranked modes, in the order they are preferred when players agree on more than one.
+var LeagueModes = []string{"Splat Zones", "Tower Control", "Rainmaker", "Clam Blitz"}
+
+// modeNames are the names players can use for each ranked mode.
+var modeNames = map[string]string{
+	"zones":     "Splat Zones",
+	"sz":        "Splat Zones",
+	"tower":     "Tower Control",
+	"tc":        "Tower Control",
+	"rainmaker": "Rainmaker",
+	"rm":        "Rainmaker",
+	"clams":     "Clam Blitz",
+	"cb":        "Clam Blitz",
+}
+
+// ParseMode gets the ranked mode for a name a player typed, such as "zones".
+func ParseMode(name string) (string, bool) {
+	mode, ok := modeNames[strings.ToLower(name)]
+	return mode, ok
+}
+
+// DefaultMapPool gets a pool with every ranked mode on every stage, for a best of 5.
+func DefaultMapPool() *MapPool {
+	pool := &MapPool{BestOf: 5}
+	for _, mode := range LeagueModes {
+		pool.Modes = append(pool.Modes, ModePool{Name: mode, Stages: splatoonStages})
+	}
+	return pool
+}
+
+// LoadMapPool reads a map pool from a JSON file, or from a YAML file if its extension is .yaml or .yml.
+func LoadMapPool(path string) (*MapPool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pool := new(MapPool)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(file).Decode(pool)
+	default:
+		err = json.NewDecoder(file).Decode(pool)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read map pool %s: %v", path, err)
+	}
+	if err := pool.validate(); err != nil {
+		return nil, fmt.Errorf("invalid map pool %s: %v", path, err)
+	}
+	return pool, nil
+}
+
+// validate checks that the pool has enough different stages for a set.
+func (pool *MapPool) validate() error {
+	if pool.BestOf < 1 {
+		return errors.New("bestOf must be at least 1")
+	}
+	if len(pool.Modes) == 0 {
+		return errors.New("at least one mode is required")
+	}
+
+	stages := make(map[string]bool)
+	for _, mode := range pool.Modes {
+		if len(mode.Stages) == 0 {
+			return fmt.Errorf("mode %s has no stages", mode.Name)
+		}
+		for _, stage := range mode.Stages {
+			stages[stage] = true
+		}
+	}
+	if len(stages) < pool.BestOf {
+		return fmt.Errorf("a best of %d needs at least %d different stages", pool.BestOf, pool.BestOf)
+	}
+
+	_, err := pool.Generate()
+	return err
+}
+
+// Generate creates a set of games that does not play any stage twice.
+func (pool *MapPool) Generate() ([]MapPick, error) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for attempt := 0; attempt < mapListAttempts; attempt++ {
+		if set := pool.generate(rng); set != nil {
+			return set, nil
+		}
+	}
+	return nil, errors.New("could not pick a different stage for every game")
+}
+
+// generate picks a random unused stage for each game, or returns nil if it runs out of stages.
+func (pool *MapPool) generate(rng *rand.Rand) []MapPick {
+	used := make(map[string]bool)
+	set := make([]MapPick, 0, pool.BestOf)
+	for game := 0; game < pool.BestOf; game++ {
+		mode := pool.Modes[game%len(pool.Modes)]
+
+		var stages []string
+		for _, stage := range mode.Stages {
+			if !used[stage] {
+				stages = append(stages, stage)
+			}
+		}
+		if len(stages) == 0 {
+			return nil
+		}
+
+		stage := stages[rng.Intn(len(stages))]
+		used[stage] = true
+		set = append(set, MapPick{Mode: mode.Name, Stage: stage})
+	}
+	return set
+}
+
+// FormatMapList writes a set as a numbered list.
+func FormatMapList(set []MapPick) string {
+	msg := fmt.Sprintf("Best of %d:", len(set))
+	for i, pick := range set {
+		msg += fmt.Sprintf("\n%d. %s on %s", i+1, pick.Mode, pick.Stage)
+	}
+	return msg
+}
@@ -0,0 +1,279 @@
+package pickup
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// strikeStages is how many stages captains strike from before each game.
+const strikeStages = 5
+
+// OptionEmoji are the reactions used to choose options in a pick/ban prompt, in order.
+var OptionEmoji = []string{"🇦", "🇧", "🇨", "🇩", "🇪", "🇫", "🇬", "🇭", "🇮", "🇯"}
+
+// PickBanState is the step of a pick/ban set.
+type PickBanState int
+
+const (
+	// PickBanStrike is when captains take turns striking stages until one is left
+	PickBanStrike PickBanState = iota
+	// PickBanPlaying is when a game is being played and its result has not been reported
+	PickBanPlaying
+	// PickBanCounterpick is when the loser of the last game picks the next mode
+	PickBanCounterpick
+	// PickBanFinished is when one side has won the set
+	PickBanFinished
+)
+
+// PickBan tracks a set between two captains where stages are struck and the loser of each game picks the next mode.
+type PickBan struct {
+	Captains  [2]string
+	BestOf    int
+	Games     []MapPick
+	Wins      [2]int
+	MessageID string
+
+	state   PickBanState
+	turn    int
+	mode    string
+	options []string
+	pool    *MapPool
+	rng     *rand.Rand
+	mutex   sync.Mutex
+}
+
+// NewPickBan starts a set between two captains. The first game is played in the first mode of the pool, and the first captain strikes first.
+func NewPickBan(pool *MapPool, captains [2]string) *PickBan {
+	pb := &PickBan{
+		Captains: captains,
+		BestOf:   pool.BestOf,
+		pool:     pool,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	pb.startStrike(pool.Modes[0].Name, 0)
+	return pb
+}
+
+// startStrike picks the stages to strike from for a mode. Stages that were already played in the set are left out.
+func (pb *PickBan) startStrike(mode string, turn int) {
+	played := make(map[string]bool)
+	for _, game := range pb.Games {
+		played[game.Stage] = true
+	}
+
+	var stages []string
+	for _, m := range pb.pool.Modes {
+		if m.Name != mode {
+			continue
+		}
+		for _, stage := range m.Stages {
+			if !played[stage] {
+				stages = append(stages, stage)
+			}
+		}
+	}
+	pb.rng.Shuffle(len(stages), func(i, j int) { stages[i], stages[j] = stages[j], stages[i] })
+	if len(stages) > strikeStages {
+		stages = stages[:strikeStages]
+	}
+
+	pb.mode = mode
+	pb.turn = turn
+	pb.options = stages
+	pb.state = PickBanStrike
+	if len(stages) <= 1 {
+		pb.startGame()
+	}
+}
+
+// startGame plays the stage that is left after striking.
+func (pb *PickBan) startGame() {
+	stage := "any stage"
+	if len(pb.options) > 0 {
+		stage = pb.options[0]
+	}
+	pb.Games = append(pb.Games, MapPick{Mode: pb.mode, Stage: stage})
+	pb.options = nil
+	pb.state = PickBanPlaying
+}
+
+// Side gets which side a captain is on, or -1 if they are not a captain.
+func (pb *PickBan) Side(captainID string) int {
+	for i, id := range pb.Captains {
+		if id == captainID {
+			return i
+		}
+	}
+	return -1
+}
+
+// State gets the current step of the set.
+func (pb *PickBan) State() PickBanState {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	return pb.state
+}
+
+// Choose strikes a stage or picks a mode for a captain, from a reaction to the prompt with the given message ID.
+func (pb *PickBan) Choose(messageID string, captainID string, option int) error {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	if pb.MessageID == "" || messageID != pb.MessageID {
+		return errors.New("that is not the current prompt")
+	}
+	if pb.state != PickBanStrike && pb.state != PickBanCounterpick {
+		return errors.New("there is nothing to choose")
+	}
+	if pb.Side(captainID) != pb.turn {
+		return errors.New("it is not your turn")
+	}
+	if option < 0 || option >= len(pb.options) {
+		return errors.New("that is not an option")
+	}
+
+	// The prompt is answered, so ignore reactions to it until the next one is posted
+	pb.MessageID = ""
+
+	if pb.state == PickBanCounterpick {
+		// The winner of the last game strikes first
+		pb.startStrike(pb.options[option], 1-pb.turn)
+		return nil
+	}
+
+	pb.options = append(pb.options[:option], pb.options[option+1:]...)
+	if len(pb.options) == 1 {
+		pb.startGame()
+	} else {
+		pb.turn = 1 - pb.turn
+	}
+	return nil
+}
+
+// Report records the winner of the current game. The loser picks the next mode from the modes that have not been played yet.
+func (pb *PickBan) Report(winnerID string) error {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	if pb.state != PickBanPlaying {
+		return errors.New("there is no game being played")
+	}
+	winner := pb.Side(winnerID)
+	if winner < 0 {
+		return errors.New("that player is not a captain")
+	}
+
+	pb.Wins[winner]++
+	if pb.Wins[winner] > pb.BestOf/2 {
+		pb.state = PickBanFinished
+		pb.MessageID = ""
+		return nil
+	}
+
+	played := make(map[string]bool)
+	for _, game := range pb.Games {
+		played[game.Mode] = true
+	}
+	var modes []string
+	for _, mode := range pb.pool.Modes {
+		if !played[mode.Name] {
+			modes = append(modes, mode.Name)
+		}
+	}
+	if len(modes) == 0 {
+		for _, mode := range pb.pool.Modes {
+			modes = append(modes, mode.Name)
+		}
+	}
+
+	if len(modes) > len(OptionEmoji) {
+		modes = modes[:len(OptionEmoji)]
+	}
+
+	pb.turn = 1 - winner
+	pb.options = modes
+	pb.state = PickBanCounterpick
+	if len(modes) == 1 {
+		pb.startStrike(modes[0], winner)
+	}
+	return nil
+}
+
+// Winner gets the captain who won the set, or an empty string if it is not finished.
+func (pb *PickBan) Winner() string {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	if pb.state != PickBanFinished {
+		return ""
+	}
+	if pb.Wins[0] > pb.Wins[1] {
+		return pb.Captains[0]
+	}
+	return pb.Captains[1]
+}
+
+// Prompt describes the current step of the set and how many options can be reacted to.
+func (pb *PickBan) Prompt() (string, int) {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	msg := fmt.Sprintf("Game %d, score %d-%d.", len(pb.Games)+1, pb.Wins[0], pb.Wins[1])
+	switch pb.state {
+	case PickBanStrike:
+		msg += fmt.Sprintf(" %s.\n<@%s>: React to strike a stage.", pb.mode, pb.Captains[pb.turn])
+	case PickBanCounterpick:
+		msg += fmt.Sprintf("\n<@%s>: React to pick the next mode.", pb.Captains[pb.turn])
+	case PickBanPlaying:
+		game := pb.Games[len(pb.Games)-1]
+		return fmt.Sprintf(Commands("Game %d: %s on %s. Captains, report the winner with \"!result win\" or \"!result loss\"."), len(pb.Games), game.Mode, game.Stage), 0
+	case PickBanFinished:
+		winner := 0
+		if pb.Wins[1] > pb.Wins[0] {
+			winner = 1
+		}
+		return fmt.Sprintf("<@%s>'s side wins the set %d-%d! GG!", pb.Captains[winner], pb.Wins[0], pb.Wins[1]), 0
+	}
+
+	for i, option := range pb.options {
+		msg += fmt.Sprintf("\n%s %s", OptionEmoji[i], option)
+	}
+	return msg, len(pb.options)
+}
+
+// PostPrompt sends the current step of the set to a channel with a reaction for each option.
+func (pb *PickBan) PostPrompt(session *discordgo.Session, channelID string) error {
+	msg, options := pb.Prompt()
+	message, err := session.ChannelMessageSend(channelID, msg)
+	if err != nil {
+		return err
+	}
+	if options == 0 {
+		return nil
+	}
+
+	pb.mutex.Lock()
+	pb.MessageID = message.ID
+	pb.mutex.Unlock()
+
+	for i := 0; i < options; i++ {
+		session.MessageReactionAdd(channelID, message.ID, OptionEmoji[i])
+	}
+	return nil
+}
+
+// OptionIndex gets which option a reaction chooses, or -1 if it is not an option reaction.
+func OptionIndex(emoji string) int {
+	for i, e := range OptionEmoji {
+		if e == emoji {
+			return i
+		}
+	}
+	return -1
+}
@@ -1,8 +1,113 @@
 package pickup
 
+import (
+	"strings"
+	"sync"
+)
+
+// Player holds the details of a registered player. Matchmaking state is kept in a Registry.
 type Player struct {
-	ID          string
-	FriendCode  string
-	IsSearching bool
-	IsInMatch   bool
+	ID         string
+	FriendCode string
+	avoids     map[string]bool
+	notify     NotifySettings
+	region     string
+	mutex      sync.RWMutex
+}
+
+// Regions are the regions players can choose so they are matched with players nearby.
+var Regions = []string{"NA", "EU", "JP"}
+
+// ParseRegion gets the region for a name a player typed, such as "eu".
+func ParseRegion(name string) (string, bool) {
+	for _, region := range Regions {
+		if strings.EqualFold(region, name) {
+			return region, true
+		}
+	}
+	return "", false
+}
+
+// NotifySettings are how a player wants to be told about matches and queues.
+type NotifySettings struct {
+	// Match sends the player a DM when a match is found for them
+	Match bool
+	// Ping gives the player the role that is pinged when a queue is almost full
+	Ping bool
+	// Follow are the queue types the player gets a DM about when they need one more player
+	Follow []int
+}
+
+// Follows checks if the settings follow a queue type.
+func (settings NotifySettings) Follows(queueType int) bool {
+	for _, t := range settings.Follow {
+		if t == queueType {
+			return true
+		}
+	}
+	return false
+}
+
+// Avoid adds a player to this player's avoid list.
+func (player *Player) Avoid(id string) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if player.avoids == nil {
+		player.avoids = make(map[string]bool)
+	}
+	player.avoids[id] = true
+}
+
+// Unavoid removes a player from this player's avoid list.
+func (player *Player) Unavoid(id string) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	delete(player.avoids, id)
+}
+
+// Avoids checks if this player is avoiding another player.
+func (player *Player) Avoids(id string) bool {
+	player.mutex.RLock()
+	defer player.mutex.RUnlock()
+
+	return player.avoids[id]
+}
+
+// CanPlayWith checks that neither player is avoiding the other.
+func (player *Player) CanPlayWith(other *Player) bool {
+	return !player.Avoids(other.ID) && !other.Avoids(player.ID)
+}
+
+// Notify gets the player's notification settings.
+func (player *Player) Notify() NotifySettings {
+	player.mutex.RLock()
+	defer player.mutex.RUnlock()
+
+	return player.notify
+}
+
+// SetNotify changes the player's notification settings.
+func (player *Player) SetNotify(settings NotifySettings) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	player.notify = settings
+}
+
+// Region gets the player's region, or an empty string if they have not chosen one.
+func (player *Player) Region() string {
+	player.mutex.RLock()
+	defer player.mutex.RUnlock()
+
+	return player.region
+}
+
+// SetRegion changes the player's region.
+func (player *Player) SetRegion(region string) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	player.region = region
 }
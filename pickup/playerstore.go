@@ -2,7 +2,7 @@ package pickup
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 )
 
 // PlayerStore is an interface for structs that can store player objects
@@ -12,6 +12,14 @@ type PlayerStore interface {
 	UpdateFriendCode(id string, fc string)
 	GetFriendCode(id string) string
 	GetPlayer(id string) *Player
+	AddAvoid(id string, avoidID string)
+	RemoveAvoid(id string, avoidID string)
+	GetAvoids(id string) []string
+	GetNotifySettings(id string) NotifySettings
+	SetNotifySettings(id string, settings NotifySettings)
+	GetFollowers(queueType int) []string
+	GetRegion(id string) string
+	SetRegion(id string, region string)
 }
 
 // SQLitePlayerStore implements PlayerStore and uses a SQLite database to store player data
@@ -20,18 +28,20 @@ type SQLitePlayerStore struct {
 }
 
 func (ps SQLitePlayerStore) PlayerExists(id string) bool {
-	rows, err := ps.DB.Query("SELECT * FROM Players WHERE DiscordID = ?", id)
+	var exists bool
+	err := ps.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM Players WHERE DiscordID = ?)", id).Scan(&exists)
 	if err != nil {
-		log.Print(err)
+		slog.Error("Error occurred while checking player", "player", id, "err", err)
+		return false
 	}
-	return rows.Next()
+	return exists
 }
 
 func (ps SQLitePlayerStore) Register(id string, fc string) {
 	tx, _ := ps.DB.Begin()
 	_, err := ps.DB.Exec("INSERT INTO Players (DiscordID, FriendCode) VALUES (?, ?)", id, fc)
 	if err != nil {
-		log.Print(err)
+		slog.Error("Error occurred while registering player", "player", id, "err", err)
 	}
 	tx.Commit()
 }
@@ -40,16 +50,16 @@ func (ps SQLitePlayerStore) UpdateFriendCode(id string, fc string) {
 	tx, _ := ps.DB.Begin()
 	_, err := ps.DB.Exec("UPDATE Players SET FriendCode = ? WHERE DiscordID = ?", fc, id)
 	if err != nil {
-		log.Print(err)
+		slog.Error("Error occurred while updating friend code", "player", id, "err", err)
 	}
 	tx.Commit()
 }
 
 func (ps SQLitePlayerStore) GetFriendCode(id string) string {
 	var fc string
-	rows, _ := ps.DB.Query("SELECT FriendCode FROM Players WHERE DiscordID = ?", id)
-	if rows.Next() {
-		rows.Scan(&fc)
+	err := ps.DB.QueryRow("SELECT FriendCode FROM Players WHERE DiscordID = ?", id).Scan(&fc)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Error occurred while getting friend code", "player", id, "err", err)
 	}
 	return fc
 }
@@ -58,5 +68,120 @@ func (ps SQLitePlayerStore) GetPlayer(id string) *Player {
 	player := new(Player)
 	player.ID = id
 	player.FriendCode = ps.GetFriendCode(id)
+	for _, avoidID := range ps.GetAvoids(id) {
+		player.Avoid(avoidID)
+	}
+	player.SetNotify(ps.GetNotifySettings(id))
+	player.SetRegion(ps.GetRegion(id))
 	return player
 }
+
+func (ps SQLitePlayerStore) AddAvoid(id string, avoidID string) {
+	_, err := ps.DB.Exec("INSERT OR IGNORE INTO Avoids (DiscordID, AvoidID) VALUES (?, ?)", id, avoidID)
+	if err != nil {
+		slog.Error("Error occurred while adding avoid", "player", id, "avoid", avoidID, "err", err)
+	}
+}
+
+func (ps SQLitePlayerStore) RemoveAvoid(id string, avoidID string) {
+	_, err := ps.DB.Exec("DELETE FROM Avoids WHERE DiscordID = ? AND AvoidID = ?", id, avoidID)
+	if err != nil {
+		slog.Error("Error occurred while removing avoid", "player", id, "avoid", avoidID, "err", err)
+	}
+}
+
+func (ps SQLitePlayerStore) GetAvoids(id string) []string {
+	var avoids []string
+	rows, err := ps.DB.Query("SELECT AvoidID FROM Avoids WHERE DiscordID = ?", id)
+	if err != nil {
+		slog.Error("Error occurred while getting avoids", "player", id, "err", err)
+		return avoids
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var avoidID string
+		rows.Scan(&avoidID)
+		avoids = append(avoids, avoidID)
+	}
+	return avoids
+}
+
+func (ps SQLitePlayerStore) GetNotifySettings(id string) NotifySettings {
+	var settings NotifySettings
+	row := ps.DB.QueryRow("SELECT MatchDM, PingRole FROM Notifications WHERE DiscordID = ?", id)
+	if err := row.Scan(&settings.Match, &settings.Ping); err != nil && err != sql.ErrNoRows {
+		slog.Error("Error occurred while getting notification settings", "player", id, "err", err)
+	}
+
+	rows, err := ps.DB.Query("SELECT QueueType FROM NotifyFollows WHERE DiscordID = ?", id)
+	if err != nil {
+		slog.Error("Error occurred while getting notification settings", "player", id, "err", err)
+		return settings
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var queueType int
+		rows.Scan(&queueType)
+		settings.Follow = append(settings.Follow, queueType)
+	}
+	return settings
+}
+
+func (ps SQLitePlayerStore) SetNotifySettings(id string, settings NotifySettings) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		slog.Error("Error occurred while saving notification settings", "player", id, "err", err)
+		return
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO Notifications (DiscordID, MatchDM, PingRole) VALUES (?, ?, ?)", id, settings.Match, settings.Ping)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM NotifyFollows WHERE DiscordID = ?", id)
+	}
+	for _, queueType := range settings.Follow {
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO NotifyFollows (DiscordID, QueueType) VALUES (?, ?)", id, queueType)
+		}
+	}
+	if err != nil {
+		slog.Error("Error occurred while saving notification settings", "player", id, "err", err)
+		tx.Rollback()
+		return
+	}
+	tx.Commit()
+}
+
+func (ps SQLitePlayerStore) GetFollowers(queueType int) []string {
+	var ids []string
+	rows, err := ps.DB.Query("SELECT DiscordID FROM NotifyFollows WHERE QueueType = ?", queueType)
+	if err != nil {
+		slog.Error("Error occurred while getting followers", "queue", queueType, "err", err)
+		return ids
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (ps SQLitePlayerStore) GetRegion(id string) string {
+	var region string
+	err := ps.DB.QueryRow("SELECT Region FROM Regions WHERE DiscordID = ?", id).Scan(&region)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Error occurred while getting region", "player", id, "err", err)
+	}
+	return region
+}
+
+func (ps SQLitePlayerStore) SetRegion(id string, region string) {
+	_, err := ps.DB.Exec("INSERT OR REPLACE INTO Regions (DiscordID, Region) VALUES (?, ?)", id, region)
+	if err != nil {
+		slog.Error("Error occurred while setting region", "player", id, "err", err)
+	}
+}
@@ -0,0 +1,134 @@
+package pickup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PresenceRule is what happens to a searching player whose status changes to a given status.
+type PresenceRule struct {
+	// Grace is how long the player can keep the status before being removed from the queues
+	Grace time.Duration
+	// Warn pings the player when the grace period starts
+	Warn bool
+}
+
+// PresencePolicy removes searching players who keep certain statuses for too long.
+// Statuses without a rule never remove players.
+type PresencePolicy struct {
+	Rules   map[string]PresenceRule
+	pending map[string]*pendingRemoval
+	mutex   sync.Mutex
+}
+
+// pendingRemoval is a player who will be removed when their grace period ends.
+type pendingRemoval struct {
+	status string
+	timer  *time.Timer
+}
+
+// DefaultPresencePolicy removes players who go offline straight away, and players who stay idle for 10 minutes after a warning.
+func DefaultPresencePolicy() *PresencePolicy {
+	return NewPresencePolicy(map[string]PresenceRule{
+		"offline": {},
+		"idle":    {Grace: 10 * time.Minute, Warn: true},
+	})
+}
+
+// NewPresencePolicy creates a policy with the given rules.
+func NewPresencePolicy(rules map[string]PresenceRule) *PresencePolicy {
+	return &PresencePolicy{Rules: rules, pending: make(map[string]*pendingRemoval)}
+}
+
+// ParsePresencePolicy reads rules written as "status=grace" separated by commas, such as "offline=0s,idle=10m:warn,dnd=30m".
+// Adding ":warn" pings the player when the grace period starts.
+func ParsePresencePolicy(spec string) (*PresencePolicy, error) {
+	rules := make(map[string]PresenceRule)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.SplitN(part, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("presence rule %q must be status=grace", part)
+		}
+		status := strings.ToLower(strings.TrimSpace(fields[0]))
+		if status != "offline" && status != "idle" && status != "dnd" {
+			return nil, fmt.Errorf("unknown status %q", status)
+		}
+
+		var rule PresenceRule
+		value := fields[1]
+		if strings.HasSuffix(value, ":warn") {
+			rule.Warn = true
+			value = strings.TrimSuffix(value, ":warn")
+		}
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			return nil, fmt.Errorf("invalid grace period %q for %s", value, status)
+		}
+		rule.Grace = grace
+		rules[status] = rule
+	}
+	return NewPresencePolicy(rules), nil
+}
+
+// Update applies the policy to a player's new status. If the status has a rule, remove is called once the player
+// has kept the status for the grace period. Any earlier pending removal for the player is cancelled.
+// Returns the rule and true if a new grace period was started, so the caller can warn the player.
+func (policy *PresencePolicy) Update(playerID string, status string, remove func()) (PresenceRule, bool) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	rule, ok := policy.Rules[status]
+	if pending, exists := policy.pending[playerID]; exists {
+		// Presence updates repeat for activity changes, so keep counting if the status is the same
+		if ok && pending.status == status {
+			return rule, false
+		}
+		pending.timer.Stop()
+		delete(policy.pending, playerID)
+	}
+	if !ok {
+		return rule, false
+	}
+
+	pending := &pendingRemoval{status: status}
+	pending.timer = time.AfterFunc(rule.Grace, func() {
+		policy.mutex.Lock()
+		current := policy.pending[playerID]
+		if current == pending {
+			delete(policy.pending, playerID)
+		}
+		policy.mutex.Unlock()
+
+		if current == pending {
+			remove()
+		}
+	})
+	policy.pending[playerID] = pending
+	return rule, true
+}
+
+// SetRules replaces the policy's rules. Removals that are already pending keep their grace period.
+func (policy *PresencePolicy) SetRules(rules map[string]PresenceRule) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	policy.Rules = rules
+}
+
+// Cancel stops any pending removal for a player.
+func (policy *PresencePolicy) Cancel(playerID string) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	if pending, ok := policy.pending[playerID]; ok {
+		pending.timer.Stop()
+		delete(policy.pending, playerID)
+	}
+}
@@ -1,66 +1,383 @@
 package pickup
 
-import "sync"
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueueEntry is a player waiting in a queue and what they are willing to play.
+type QueueEntry struct {
+	Player *Player
+	// Modes are the modes the player wants to play. Empty means any mode.
+	Modes []string
+	// Group is shared by players who queued together so they are always matched together. It is 0 for players who queued alone.
+	Group int
+	// Region is the player's region when they joined, or empty if they have not chosen one.
+	Region string
+	Joined time.Time
+	// Active is when the player joined or last confirmed they are still searching.
+	Active time.Time
+}
 
 // Queue is a queue of players.
 type Queue struct {
 	RequiredPlayers int
-	Players         []*Player
-	mutex           sync.Mutex
+	Entries         []*QueueEntry
+	// RegionWait is how long players wait before they can be matched with players from other regions.
+	RegionWait time.Duration
+	// Grew is called when players join the queue without a match being found, with the number of entries before and after.
+	Grew       func(before int, after int)
+	groupCount int
+	mutex      sync.Mutex
 }
 
 // Enqueue adds a player to the queue. If the queue becomes filled when the player is added, a room is created and returned.
-func (queue *Queue) Enqueue(player *Player) *Room {
+// modes : The modes the player wants to play, or nil for any mode
+func (queue *Queue) Enqueue(player *Player, modes []string) *Room {
 	queue.mutex.Lock()
 	defer queue.mutex.Unlock()
 
-	queue.Players = append(queue.Players, player)
+	now := time.Now()
+	before := len(queue.Entries)
+	queue.Entries = append(queue.Entries, &QueueEntry{Player: player, Modes: modes, Region: player.Region(), Joined: now, Active: now})
+	defer queue.grew(before)
 
-	if len(queue.Players) == queue.RequiredPlayers {
-		room := new(Room)
-		room.Size = queue.RequiredPlayers
-		for i := 0; i < queue.RequiredPlayers; i++ {
-			room.AddPlayer(queue.Dequeue())
+	return queue.matchAny()
+}
+
+// Rematch tries to form a room from the players already in the queue, such as after players have waited long enough
+// to be matched with other regions. Returns the room, or nil if no room could be formed.
+func (queue *Queue) Rematch() *Room {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.matchAny()
+}
+
+// matchAny tries to form a room around each entry, starting from the front of the queue. The caller must hold the queue's mutex.
+func (queue *Queue) matchAny() *Room {
+	for _, e := range queue.Entries {
+		matched, mode, ok := queue.match(queue.groupOf(e))
+		if !ok {
+			continue
 		}
-		return room
+
+		return queue.createRoom(matched, mode)
 	}
 
 	return nil
 }
 
 // EnqueueTeam adds a group of players to the queue. If the queue becomes filled when the team is added, a room is created and returned.
-func (queue *Queue) EnqueueTeam(players []*Player) *Room {
+// modes : The modes the team wants to play, or nil for any mode
+func (queue *Queue) EnqueueTeam(players []*Player, modes []string) *Room {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.groupCount++
+	now := time.Now()
+	var team []*QueueEntry
+	for _, p := range players {
+		team = append(team, &QueueEntry{Player: p, Modes: modes, Group: queue.groupCount, Region: p.Region(), Joined: now, Active: now})
+	}
+
+	if matched, mode, ok := queue.match(team); ok {
+		return queue.createRoom(matched, mode)
+	}
+
+	before := len(queue.Entries)
+	queue.Entries = append(queue.Entries, team...)
+	queue.grew(before)
+
+	return nil
+}
+
+// GroupRooms splits players who are not in the queue into full rooms, in order, keeping players who avoid each other apart.
+// Each player joins the first room that has space and nobody they avoid. Players who do not end up in a full room are returned.
+// If a room cannot be set up, its players are added to the back of the queue.
+func (queue *Queue) GroupRooms(players []*Player) ([]*Room, []*Player) {
 	queue.mutex.Lock()
 	defer queue.mutex.Unlock()
 
-	if len(queue.Players)+len(players) >= queue.RequiredPlayers {
+	now := time.Now()
+	var groups [][]*QueueEntry
+	for _, p := range players {
+		entry := &QueueEntry{Player: p, Region: p.Region(), Joined: now, Active: now}
+		placed := false
+		for i, group := range groups {
+			if len(group) < queue.RequiredPlayers && canJoin(group, []*QueueEntry{entry}) {
+				groups[i] = append(group, entry)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			groups = append(groups, []*QueueEntry{entry})
+		}
+	}
+
+	var rooms []*Room
+	grouped := make(map[*Player]bool)
+	for _, group := range groups {
+		if len(group) < queue.RequiredPlayers {
+			continue
+		}
+
+		queue.groupCount++
 		room := new(Room)
+		room.ID = nextRoomID()
 		room.Size = queue.RequiredPlayers
-		for i := 0; i < len(players); i++ {
-			room.AddPlayer(players[i])
+		room.queue = queue
+		for _, e := range group {
+			e.Group = queue.groupCount
+			room.AddPlayer(e.Player)
+			room.queueEntries = append(room.queueEntries, e)
+			room.queuePositions = append(room.queuePositions, -1)
+			grouped[e.Player] = true
 		}
-		for i := len(players); i < queue.RequiredPlayers; i++ {
-			room.AddPlayer(queue.Dequeue())
+		rooms = append(rooms, room)
+	}
+
+	var left []*Player
+	for _, p := range players {
+		if !grouped[p] {
+			left = append(left, p)
 		}
-		return room
 	}
+	return rooms, left
+}
+
+// grew calls Grew if the queue has more entries than before. The caller must hold the queue's mutex.
+func (queue *Queue) grew(before int) {
+	if after := len(queue.Entries); queue.Grew != nil && after > before {
+		go queue.Grew(before, after)
+	}
+}
 
-	queue.Players = append(queue.Players, players...)
+// createRoom removes the matched entries from the queue and creates a room for them to play the agreed mode.
+// The entries' positions in the queue are kept so they can be restored if the room cannot be set up.
+func (queue *Queue) createRoom(matched []*QueueEntry, mode string) *Room {
+	room := new(Room)
+	room.ID = nextRoomID()
+	room.Size = queue.RequiredPlayers
+	room.Mode = mode
+	room.queue = queue
+	for _, e := range matched {
+		room.AddPlayer(e.Player)
+		room.queueEntries = append(room.queueEntries, e)
+		room.queuePositions = append(room.queuePositions, queue.position(e.Player))
+	}
+	for _, e := range matched {
+		queue.remove(e.Player)
+	}
+	return room
+}
 
-	return nil
+// Restore puts entries back into the queue at the positions they were taken from.
+// Entries with a negative position were never in the queue and are added to the back.
+func (queue *Queue) Restore(entries []*QueueEntry, positions []int) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	type restored struct {
+		entry    *QueueEntry
+		position int
+	}
+	var placed, newcomers []restored
+	for i, e := range entries {
+		if positions[i] < 0 {
+			newcomers = append(newcomers, restored{e, positions[i]})
+		} else {
+			placed = append(placed, restored{e, positions[i]})
+		}
+	}
+
+	// Inserting from the lowest position upwards puts every entry back where it was
+	sort.Slice(placed, func(i, j int) bool { return placed[i].position < placed[j].position })
+	for _, r := range placed {
+		position := r.position
+		if position > len(queue.Entries) {
+			position = len(queue.Entries)
+		}
+		queue.Entries = append(queue.Entries, nil)
+		copy(queue.Entries[position+1:], queue.Entries[position:])
+		queue.Entries[position] = r.entry
+	}
+	for _, r := range newcomers {
+		queue.Entries = append(queue.Entries, r.entry)
+	}
+}
+
+// position gets the index of a player in the queue, or -1 if the player is not in the queue.
+func (queue *Queue) position(player *Player) int {
+	for i, e := range queue.Entries {
+		if e.Player == player {
+			return i
+		}
+	}
+	return -1
+}
+
+// groupOf gets an entry together with every other entry in the queue that queued with it.
+func (queue *Queue) groupOf(entry *QueueEntry) []*QueueEntry {
+	if entry.Group == 0 {
+		return []*QueueEntry{entry}
+	}
+
+	var group []*QueueEntry
+	for _, e := range queue.Entries {
+		if e.Group == entry.Group {
+			group = append(group, e)
+		}
+	}
+	return group
+}
+
+// match fills a room around a group of entries using entries from the queue, in queue order.
+// Players from the same region are tried first, then players from other regions who have waited long enough.
+// Returns the matched entries and the mode they agreed on, which is empty if any mode is fine.
+// ok is false if there are not enough players that can play together.
+func (queue *Queue) match(group []*QueueEntry) (matched []*QueueEntry, mode string, ok bool) {
+	if matched, mode, ok = queue.matchRegion(group, false); ok {
+		return matched, mode, ok
+	}
+	return queue.matchRegion(group, true)
+}
+
+// matchRegion fills a room around a group of entries. Players that queued together are added together, and players
+// that are avoiding someone already in the room, who want to play different modes, or who are from another region are skipped.
+// crossRegion : Whether players from different regions who have both waited for the queue's RegionWait can be matched
+func (queue *Queue) matchRegion(group []*QueueEntry, crossRegion bool) (matched []*QueueEntry, mode string, ok bool) {
+	modes, ok := commonModes(nil, group)
+	if !ok {
+		return nil, "", false
+	}
+
+	matched = append([]*QueueEntry(nil), group...)
+	for _, e := range queue.Entries {
+		if len(matched) >= queue.RequiredPlayers {
+			break
+		}
+		if containsEntry(matched, e) {
+			continue
+		}
+
+		candidates := queue.groupOf(e)
+		if len(matched)+len(candidates) > queue.RequiredPlayers || !canJoin(matched, candidates) {
+			continue
+		}
+		if !queue.sameRegion(matched, candidates, crossRegion) {
+			continue
+		}
+		joint, ok := commonModes(modes, candidates)
+		if !ok {
+			continue
+		}
+
+		matched = append(matched, candidates...)
+		modes = joint
+	}
+
+	if len(matched) < queue.RequiredPlayers {
+		return nil, "", false
+	}
+	return matched, agreedMode(modes), true
+}
+
+// canJoin checks if entries can join a group, meaning they are not already in it and nobody is avoiding anybody.
+func canJoin(group []*QueueEntry, entries []*QueueEntry) bool {
+	for _, e := range entries {
+		for _, g := range group {
+			if g.Player == e.Player || !g.Player.CanPlayWith(e.Player) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sameRegion checks that entries can play with a group based on their regions.
+// Players without a region can play with anyone.
+func (queue *Queue) sameRegion(group []*QueueEntry, entries []*QueueEntry, crossRegion bool) bool {
+	for _, e := range entries {
+		for _, g := range group {
+			if e.Region == "" || g.Region == "" || e.Region == g.Region {
+				continue
+			}
+			if !crossRegion || time.Since(e.Joined) < queue.RegionWait || time.Since(g.Joined) < queue.RegionWait {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsEntry checks if an entry is in a list of entries.
+func containsEntry(entries []*QueueEntry, entry *QueueEntry) bool {
+	for _, e := range entries {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// commonModes narrows a set of modes down to the modes every entry wants to play. A nil set means any mode.
+// ok is false if the entries have no modes in common.
+func commonModes(modes []string, entries []*QueueEntry) ([]string, bool) {
+	for _, e := range entries {
+		if len(e.Modes) == 0 {
+			continue
+		}
+		if modes == nil {
+			modes = e.Modes
+			continue
+		}
+
+		var joint []string
+		for _, mode := range modes {
+			for _, m := range e.Modes {
+				if m == mode {
+					joint = append(joint, mode)
+					break
+				}
+			}
+		}
+		if len(joint) == 0 {
+			return nil, false
+		}
+		modes = joint
+	}
+	return modes, true
+}
+
+// agreedMode picks the mode to play from a set of modes everyone wants, in the order of LeagueModes.
+func agreedMode(modes []string) string {
+	for _, mode := range LeagueModes {
+		for _, m := range modes {
+			if m == mode {
+				return mode
+			}
+		}
+	}
+	if len(modes) > 0 {
+		return modes[0]
+	}
+	return ""
 }
 
 // Dequeue removes a player from the front of the queue.
 func (queue *Queue) Dequeue() *Player {
-	if len(queue.Players) == 0 {
+	if len(queue.Entries) == 0 {
 		return nil
 	}
 
-	player := queue.Players[0]
-	copy(queue.Players[0:], queue.Players[1:])
-	queue.Players[len(queue.Players)-1] = nil
-	queue.Players = queue.Players[:len(queue.Players)-1]
-	return player
+	entry := queue.Entries[0]
+	copy(queue.Entries[0:], queue.Entries[1:])
+	queue.Entries[len(queue.Entries)-1] = nil
+	queue.Entries = queue.Entries[:len(queue.Entries)-1]
+	return entry.Player
 }
 
 // Remove removes a player from anywhere within the queue.
@@ -68,31 +385,115 @@ func (queue *Queue) Remove(player *Player) {
 	queue.mutex.Lock()
 	defer queue.mutex.Unlock()
 
-	for i, p := range queue.Players {
-		if p == player {
-			if i == 0 {
-				queue.Dequeue()
-			} else if i == len(queue.Players)-1 {
-				copy(queue.Players[i:], queue.Players[i+1:])
-				queue.Players[len(queue.Players)-1] = nil
-				queue.Players = queue.Players[:len(queue.Players)-1]
-			} else {
-				queue.Players[len(queue.Players)-1] = nil
-				queue.Players = queue.Players[:len(queue.Players)-1]
-			}
+	queue.remove(player)
+}
+
+// remove removes a player from the queue. The caller must hold the queue's mutex.
+func (queue *Queue) remove(player *Player) {
+	for i, e := range queue.Entries {
+		if e.Player == player {
+			copy(queue.Entries[i:], queue.Entries[i+1:])
+			queue.Entries[len(queue.Entries)-1] = nil
+			queue.Entries = queue.Entries[:len(queue.Entries)-1]
+			return
 		}
 	}
 }
 
+// Clear removes every player from the queue and returns the removed players.
+func (queue *Queue) Clear() []*Player {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var players []*Player
+	for _, e := range queue.Entries {
+		players = append(players, e.Player)
+	}
+	queue.Entries = nil
+	return players
+}
+
+// Contains checks if a player is in the queue.
+func (queue *Queue) Contains(player *Player) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.position(player) >= 0
+}
+
+// SetRegionWait changes how long players wait before they can be matched with players from other regions.
+func (queue *Queue) SetRegionWait(wait time.Duration) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.RegionWait = wait
+}
+
+// EntrySnapshot returns a copy of the entries currently in the queue.
+func (queue *Queue) EntrySnapshot() []QueueEntry {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	entries := make([]QueueEntry, 0, len(queue.Entries))
+	for _, e := range queue.Entries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// Stale gets the players who have not confirmed they are still searching within maxAge.
+func (queue *Queue) Stale(maxAge time.Duration) []*Player {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var players []*Player
+	for _, e := range queue.Entries {
+		if time.Since(e.Active) > maxAge {
+			players = append(players, e.Player)
+		}
+	}
+	return players
+}
+
+// Refresh records that a player is still searching, without changing their place in the queue.
+func (queue *Queue) Refresh(player *Player) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for _, e := range queue.Entries {
+		if e.Player == player {
+			e.Active = time.Now()
+		}
+	}
+}
+
+// Snapshot returns a copy of the players currently in the queue.
+func (queue *Queue) Snapshot() []*Player {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var players []*Player
+	for _, e := range queue.Entries {
+		players = append(players, e.Player)
+	}
+	return players
+}
+
 // Top gets the player at the front of the queue.
 func (queue *Queue) Top() *Player {
-	if len(queue.Players) == 0 {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if len(queue.Entries) == 0 {
 		return nil
 	}
-	return queue.Players[0]
+	return queue.Entries[0].Player
 }
 
 // Len returns the length of the queue.
 func (queue *Queue) Len() int {
-	return len(queue.Players)
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return len(queue.Entries)
 }
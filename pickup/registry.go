@@ -0,0 +1,165 @@
+package pickup
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PlayerState is the matchmaking state of a player.
+type PlayerState int
+
+const (
+	// StateIdle means the player is neither searching nor in a match
+	StateIdle PlayerState = iota
+	// StateSearching means the player is waiting in a queue
+	StateSearching
+	// StateReadyCheck means a match was found and the player's room is being set up
+	StateReadyCheck
+	// StateInMatch means the player is in a room
+	StateInMatch
+)
+
+// validTransitions lists the states each state can move to. Every state can also move to StateIdle.
+var validTransitions = map[PlayerState][]PlayerState{
+	StateIdle:       {StateSearching, StateInMatch},
+	StateSearching:  {StateReadyCheck},
+	StateReadyCheck: {StateSearching, StateInMatch},
+	StateInMatch:    {},
+}
+
+func (state PlayerState) String() string {
+	switch state {
+	case StateIdle:
+		return "idle"
+	case StateSearching:
+		return "searching"
+	case StateReadyCheck:
+		return "ready check"
+	case StateInMatch:
+		return "in match"
+	}
+	return "unknown"
+}
+
+// canTransition checks if a player can move from one state to another.
+func canTransition(from PlayerState, to PlayerState) bool {
+	if to == StateIdle {
+		return true
+	}
+	for _, state := range validTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when a player cannot move to a state.
+type TransitionError struct {
+	PlayerID string
+	State    PlayerState
+	From     PlayerState
+	To       PlayerState
+}
+
+func (err *TransitionError) Error() string {
+	if err.State != err.From {
+		return fmt.Sprintf("player %s is %s, not %s", err.PlayerID, err.State, err.From)
+	}
+	return fmt.Sprintf("player %s cannot go from %s to %s", err.PlayerID, err.From, err.To)
+}
+
+// Registry holds the players that have used the bot and their matchmaking state.
+// It is safe to use from multiple goroutines.
+type Registry struct {
+	mutex   sync.Mutex
+	players map[string]*Player
+	states  map[string]PlayerState
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		players: make(map[string]*Player),
+		states:  make(map[string]PlayerState),
+	}
+}
+
+// Get gets a player from the registry.
+func (registry *Registry) Get(id string) (*Player, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	player, ok := registry.players[id]
+	return player, ok
+}
+
+// Load gets a player from the registry, adding them from the store if they are not in it yet.
+func (registry *Registry) Load(id string, store PlayerStore) *Player {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	player, ok := registry.players[id]
+	if !ok {
+		player = store.GetPlayer(id)
+		registry.players[id] = player
+		registry.states[id] = StateIdle
+	}
+	return player
+}
+
+// Players gets every player in the registry.
+func (registry *Registry) Players() []*Player {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	var players []*Player
+	for _, player := range registry.players {
+		players = append(players, player)
+	}
+	return players
+}
+
+// State gets the matchmaking state of a player. Players that are not in the registry are idle.
+func (registry *Registry) State(id string) PlayerState {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	return registry.states[id]
+}
+
+// Transition moves a player from one state to another.
+// An error is returned if the player is not in the from state or cannot move to the to state.
+func (registry *Registry) Transition(id string, from PlayerState, to PlayerState) error {
+	return registry.TransitionAll([]string{id}, from, to)
+}
+
+// TransitionAll moves a group of players from one state to another.
+// Either every player is moved or, if any of them cannot be, none are.
+func (registry *Registry) TransitionAll(ids []string, from PlayerState, to PlayerState) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for _, id := range ids {
+		state := registry.states[id]
+		if state != from || !canTransition(from, to) {
+			return &TransitionError{PlayerID: id, State: state, From: from, To: to}
+		}
+	}
+	for _, id := range ids {
+		registry.states[id] = to
+	}
+	return nil
+}
+
+// Release moves a player back to idle from whatever state they are in, returning the state they were in.
+func (registry *Registry) Release(id string) PlayerState {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	state := registry.states[id]
+	if _, ok := registry.players[id]; ok {
+		registry.states[id] = StateIdle
+	}
+	return state
+}
@@ -0,0 +1,221 @@
+package pickup
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// newTestRegistry creates a registry holding players with the given states.
+func newTestRegistry(states map[string]PlayerState) *Registry {
+	registry := NewRegistry()
+	for id, state := range states {
+		registry.players[id] = &Player{ID: id}
+		registry.states[id] = state
+	}
+	return registry
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from PlayerState
+		to   PlayerState
+		want bool
+	}{
+		{StateIdle, StateIdle, true},
+		{StateIdle, StateSearching, true},
+		{StateIdle, StateReadyCheck, false},
+		{StateIdle, StateInMatch, true},
+		{StateSearching, StateIdle, true},
+		{StateSearching, StateSearching, false},
+		{StateSearching, StateReadyCheck, true},
+		{StateSearching, StateInMatch, false},
+		{StateReadyCheck, StateIdle, true},
+		{StateReadyCheck, StateSearching, true},
+		{StateReadyCheck, StateReadyCheck, false},
+		{StateReadyCheck, StateInMatch, true},
+		{StateInMatch, StateIdle, true},
+		{StateInMatch, StateSearching, false},
+		{StateInMatch, StateReadyCheck, false},
+		{StateInMatch, StateInMatch, false},
+	}
+	for _, test := range tests {
+		if got := canTransition(test.from, test.to); got != test.want {
+			t.Errorf("canTransition(%s, %s) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   PlayerState
+		from    PlayerState
+		to      PlayerState
+		wantErr bool
+		want    PlayerState
+	}{
+		{"start searching", StateIdle, StateIdle, StateSearching, false, StateSearching},
+		{"match found", StateSearching, StateSearching, StateReadyCheck, false, StateReadyCheck},
+		{"room set up", StateReadyCheck, StateReadyCheck, StateInMatch, false, StateInMatch},
+		{"room failed", StateReadyCheck, StateReadyCheck, StateSearching, false, StateSearching},
+		{"moved by a moderator", StateIdle, StateIdle, StateInMatch, false, StateInMatch},
+		{"leave match", StateInMatch, StateInMatch, StateIdle, false, StateIdle},
+		{"wrong from state", StateInMatch, StateSearching, StateIdle, true, StateInMatch},
+		{"invalid transition", StateSearching, StateSearching, StateInMatch, true, StateSearching},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newTestRegistry(map[string]PlayerState{"a": test.state})
+			err := registry.Transition("a", test.from, test.to)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Transition() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got := registry.State("a"); got != test.want {
+				t.Errorf("state = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTransitionAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  map[string]PlayerState
+		wantErr bool
+		want    PlayerState
+	}{
+		{"all searching", map[string]PlayerState{"a": StateSearching, "b": StateSearching, "c": StateSearching}, false, StateReadyCheck},
+		{"one left", map[string]PlayerState{"a": StateSearching, "b": StateIdle, "c": StateSearching}, true, StateSearching},
+		{"one matched elsewhere", map[string]PlayerState{"a": StateSearching, "b": StateSearching, "c": StateInMatch}, true, StateSearching},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newTestRegistry(test.states)
+			err := registry.TransitionAll([]string{"a", "b", "c"}, StateSearching, StateReadyCheck)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("TransitionAll() error = %v, wantErr %v", err, test.wantErr)
+			}
+			// Either everyone moves or nobody does
+			for id, state := range test.states {
+				want := state
+				if state == StateSearching {
+					want = test.want
+				}
+				if got := registry.State(id); got != want {
+					t.Errorf("state of %s = %s, want %s", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		state PlayerState
+		want  PlayerState
+	}{
+		{"searching", "a", StateSearching, StateIdle},
+		{"ready check", "a", StateReadyCheck, StateIdle},
+		{"in match", "a", StateInMatch, StateIdle},
+		{"unknown player", "b", StateIdle, StateIdle},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newTestRegistry(map[string]PlayerState{"a": test.state})
+			previous := registry.Release(test.id)
+			if test.id == "a" && previous != test.state {
+				t.Errorf("Release() = %s, want %s", previous, test.state)
+			}
+			if got := registry.State(test.id); got != test.want {
+				t.Errorf("state = %s, want %s", got, test.want)
+			}
+			if _, ok := registry.Get("b"); ok {
+				t.Error("Release() added an unknown player to the registry")
+			}
+		})
+	}
+}
+
+// TestConcurrentMatchmaking joins, leaves and matches players from many goroutines the way the bot does, then checks
+// that the queue and the registry agree. Run it with -race.
+func TestConcurrentMatchmaking(t *testing.T) {
+	const players = 200
+	registry := NewRegistry()
+	queue := &Queue{RequiredPlayers: 4}
+	var all []*Player
+	for i := 0; i < players; i++ {
+		p := &Player{ID: fmt.Sprint(i)}
+		registry.players[p.ID] = p
+		registry.states[p.ID] = StateIdle
+		all = append(all, p)
+	}
+
+	startRoom := func(room *Room) {
+		var ids []string
+		for _, p := range room.PlayerList() {
+			ids = append(ids, p.ID)
+		}
+		if err := registry.TransitionAll(ids, StateSearching, StateReadyCheck); err != nil {
+			// Someone left while the room was formed, so everyone else goes back and the leavers are removed
+			room.ReturnToQueue()
+			for _, p := range room.PlayerList() {
+				if registry.State(p.ID) == StateIdle {
+					queue.Remove(p)
+				}
+			}
+			return
+		}
+		if err := registry.TransitionAll(ids, StateReadyCheck, StateInMatch); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, p := range all {
+		wg.Add(1)
+		go func(p *Player, leave bool) {
+			defer wg.Done()
+			if registry.Transition(p.ID, StateIdle, StateSearching) != nil {
+				return
+			}
+			if room := queue.Enqueue(p, nil); room != nil {
+				startRoom(room)
+			}
+			if leave && registry.Transition(p.ID, StateSearching, StateIdle) == nil {
+				queue.Remove(p)
+			}
+		}(p, i%3 == 0)
+		if i%10 == 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				queue.Len()
+				queue.Snapshot()
+				if room := queue.Rematch(); room != nil {
+					startRoom(room)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	queued := make(map[*Player]bool)
+	for _, p := range queue.Snapshot() {
+		if queued[p] {
+			t.Errorf("player %s is in the queue twice", p.ID)
+		}
+		queued[p] = true
+	}
+	for _, p := range all {
+		state := registry.State(p.ID)
+		if state == StateReadyCheck {
+			t.Errorf("player %s was left in a ready check", p.ID)
+		}
+		if queued[p] != (state == StateSearching) {
+			t.Errorf("player %s is %s but in queue = %v", p.ID, state, queued[p])
+		}
+	}
+}
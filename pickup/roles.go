@@ -0,0 +1,122 @@
+package pickup
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// roleAttempts is the number of times a role change is attempted before giving up.
+const roleAttempts = 3
+
+// roleRetryDelay is the delay before the first retry of a role change. It doubles after each attempt.
+const roleRetryDelay = time.Second
+
+// managedRoles gets the roles the bot gives and takes away as players search for and play matches.
+func managedRoles() []string {
+	return []string{RoleSearchPair, RoleSearchQuad, RoleSearchPrivate, RoleInProgress}
+}
+
+// SearchRole gets the searching role for a type of queue, or an empty string if the queue has no searching role.
+func SearchRole(queueType int) string {
+	switch queueType {
+	case Pair:
+		return RoleSearchPair
+	case Quad:
+		return RoleSearchQuad
+	case Private:
+		return RoleSearchPrivate
+	}
+	return ""
+}
+
+// AddRole gives a member a role, retrying if the request fails. An empty role ID is ignored.
+func AddRole(s *discordgo.Session, guildID string, userID string, roleID string) error {
+	if roleID == "" {
+		return nil
+	}
+	return retryRoleChange(func() error {
+		return s.GuildMemberRoleAdd(guildID, userID, roleID)
+	}, "add", userID, roleID)
+}
+
+// RemoveRole takes a role away from a member, retrying if the request fails. An empty role ID is ignored.
+func RemoveRole(s *discordgo.Session, guildID string, userID string, roleID string) error {
+	if roleID == "" {
+		return nil
+	}
+	return retryRoleChange(func() error {
+		return s.GuildMemberRoleRemove(guildID, userID, roleID)
+	}, "remove", userID, roleID)
+}
+
+// retryRoleChange calls change until it succeeds, it fails with a client error, or it runs out of attempts.
+func retryRoleChange(change func() error, action string, userID string, roleID string) error {
+	var err error
+	delay := roleRetryDelay
+	for attempt := 1; attempt <= roleAttempts; attempt++ {
+		err = change()
+		if err == nil {
+			return nil
+		}
+
+		// Client errors such as an unknown member will not succeed on a retry
+		if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Response != nil && restErr.Response.StatusCode < 500 {
+			break
+		}
+
+		if attempt < roleAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	err = fmt.Errorf("could not %s role %s for %s: %v", action, roleID, userID, err)
+	slog.Warn("Error occurred while changing role", "action", action, "role", roleID, "player", userID, "err", err)
+	return err
+}
+
+// ReconcileRoles makes the managed roles of every guild member match the expected roles.
+// guildID  : The ID of a Guild
+// expected : Gets the managed roles a member should have when the member is checked, or false if the member should be left alone
+func ReconcileRoles(s *discordgo.Session, guildID string, expected func(userID string) ([]string, bool)) error {
+	after := ""
+	for {
+		members, err := s.GuildMembers(guildID, after, 1000)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return nil
+		}
+
+		for _, member := range members {
+			if roles, ok := expected(member.User.ID); ok {
+				reconcileMember(s, guildID, member, roles)
+			}
+		}
+		after = members[len(members)-1].User.ID
+	}
+}
+
+// reconcileMember adds the missing managed roles and removes the stale managed roles of a single member.
+func reconcileMember(s *discordgo.Session, guildID string, member *discordgo.Member, expected []string) {
+	has := make(map[string]bool)
+	for _, id := range member.Roles {
+		has[id] = true
+	}
+	want := make(map[string]bool)
+	for _, id := range expected {
+		want[id] = true
+	}
+
+	for _, role := range managedRoles() {
+		if has[role] && !want[role] {
+			RemoveRole(s, guildID, member.User.ID, role)
+		} else if want[role] && !has[role] {
+			AddRole(s, guildID, member.User.ID, role)
+		}
+	}
+}
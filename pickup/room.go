@@ -2,31 +2,68 @@ package pickup
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-var channelMutex sync.Mutex
+var roomCount int
+var roomCountMutex sync.Mutex
 
 // Room holds the IDs of channels and the players currently in the room.
 type Room struct {
+	ID            int
+	QueueType     int
+	Mode          string
+	Created       time.Time
 	Channels      []string
 	TextChannel   string
 	VoiceChannels []string
 	Size          int
 	Players       []*Player
-	Cleaning      bool
+	Teams         []*QueuedTeam
+	Closed        bool
+
+	queue          *Queue
+	queueEntries   []*QueueEntry
+	queuePositions []int
+	teamQueue      *TeamQueue
+	maps           []MapPick
+	pickBan        *PickBan
+	reported       bool
+	cleaning       bool
+	mutex          sync.Mutex
+}
+
+// Logger gets a logger that tags every line with the room's ID.
+func (room *Room) Logger() *slog.Logger {
+	return slog.With("room", room.ID)
+}
+
+// nextRoomID returns a new unique ID for a room.
+func nextRoomID() int {
+	roomCountMutex.Lock()
+	defer roomCountMutex.Unlock()
+
+	roomCount++
+	return roomCount
 }
 
 // AddPlayer adds a player to the room.
 func (room *Room) AddPlayer(player *Player) {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
 	room.Players = append(room.Players, player)
 }
 
 // RemovePlayer removes a player from the room.
 func (room *Room) RemovePlayer(player *Player) {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
 	for i, p := range room.Players {
 		if p == player {
 			copy(room.Players[i:], room.Players[i+1:])
@@ -38,11 +75,25 @@ func (room *Room) RemovePlayer(player *Player) {
 
 // PlayerCount returns the number of players in the room.
 func (room *Room) PlayerCount() int {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
 	return len(room.Players)
 }
 
+// PlayerList returns a copy of the players in the room.
+func (room *Room) PlayerList() []*Player {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	return append([]*Player(nil), room.Players...)
+}
+
 // PlayerInRoom checks if a player is in the room.
 func (room *Room) PlayerInRoom(player *Player) bool {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
 	for _, p := range room.Players {
 		if p == player {
 			return true
@@ -52,129 +103,358 @@ func (room *Room) PlayerInRoom(player *Player) bool {
 }
 
 // SetupRoom creates the channels for the room.
+// If any channel cannot be created, every channel created so far is deleted and an error is returned.
 // session   : Discord session
 // queueType : Type of queue. See const.go for values
-func (room *Room) SetupRoom(session *discordgo.Session, queueType int) {
-	channelMutex.Lock()
-	defer channelMutex.Unlock()
+func (room *Room) SetupRoom(session *discordgo.Session, queueType int) error {
+	guildID := GetGuildID(session)
+	lock := guildLock(guildID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	room.QueueType = queueType
+	room.Created = time.Now()
+
+	overwrites := room.permissionOverwrites(session, guildID, room.Players)
 
 	// Create category
-	category := room.createCategory(session)
+	category, err := room.createCategory(session, guildID, overwrites)
+	if err != nil {
+		return err
+	}
+	room.Channels = append(room.Channels, category.ID)
 
 	// Enough players are available to create a room, create channels
-	var textChan *discordgo.Channel
+	var specs []channelSpec
 	switch queueType {
 	case Pair:
-		textChan = room.createChannel(session, "pair", "text", category.ID)
-		room.createChannel(session, "Pair", "voice", category.ID)
+		specs = []channelSpec{{"pair", "text", nil}, {"Pair", "voice", nil}}
 	case Quad:
-		textChan = room.createChannel(session, "quad", "text", category.ID)
-		room.createChannel(session, "Quad", "voice", category.ID)
+		specs = []channelSpec{{"quad", "text", nil}, {"Quad", "voice", nil}}
 	case Private:
-		textChan = room.createChannel(session, "private", "text", category.ID)
-		room.createChannel(session, "Team Alpha", "voice", category.ID)
-		room.createChannel(session, "Team Beta", "voice", category.ID)
+		specs = []channelSpec{{"private", "text", nil}, {"Team Alpha", "voice", nil}, {"Team Beta", "voice", nil}}
+	case Scrim:
+		// Each team can only join its own voice channel
+		specs = []channelSpec{{"scrim", "text", nil}}
+		for _, team := range room.Teams {
+			specs = append(specs, channelSpec{team.Team.Name, "voice", team.Players})
+		}
 	}
 
-	room.sendIntroMessage(session, textChan.ID)
+	// Channels are independent of each other, so create them concurrently and let discordgo handle the rate limit
+	channels := make([]*discordgo.Channel, len(specs))
+	errs := make([]error, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func(i int, spec channelSpec) {
+			defer wg.Done()
+			channelOverwrites := overwrites
+			if spec.players != nil {
+				channelOverwrites = room.permissionOverwrites(session, guildID, spec.players)
+			}
+			channels[i], errs[i] = room.createChannel(session, guildID, spec.name, spec.channelType, category.ID, channelOverwrites)
+		}(i, spec)
+	}
+	wg.Wait()
 
-	guildID := GetGuildID(session)
+	for i, channel := range channels {
+		if errs[i] != nil {
+			continue
+		}
+		room.Channels = append(room.Channels, channel.ID)
+		if specs[i].channelType == "text" {
+			room.TextChannel = channel.ID
+		} else {
+			room.VoiceChannels = append(room.VoiceChannels, channel.ID)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			room.rollback(session)
+			return err
+		}
+	}
 
-	// Update player roles and flag them as in a match
+	if err := room.sendIntroMessage(session, room.TextChannel); err != nil {
+		room.rollback(session)
+		return fmt.Errorf("could not send intro message: %v", err)
+	}
+
+	// Update player roles
 	for _, player := range room.Players {
-		player.IsSearching = false
-		player.IsInMatch = true
-
-		switch queueType {
-		case Pair:
-			session.GuildMemberRoleRemove(guildID, player.ID, RoleSearchPair)
-		case Quad:
-			session.GuildMemberRoleRemove(guildID, player.ID, RoleSearchQuad)
-		case Private:
-			session.GuildMemberRoleRemove(guildID, player.ID, RoleSearchPrivate)
+		wg.Add(1)
+		go func(player *Player) {
+			defer wg.Done()
+			RemoveRole(session, guildID, player.ID, SearchRole(queueType))
+			AddRole(session, guildID, player.ID, RoleInProgress)
+		}(player)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// rollback deletes every channel created for the room. The caller must hold the guild lock.
+func (room *Room) rollback(session *discordgo.Session) {
+	room.deleteChannels(session)
+	room.Channels = nil
+	room.TextChannel = ""
+	room.VoiceChannels = nil
+}
+
+// ReturnToQueue puts the room's players back into the queue that created the room, at their original positions.
+func (room *Room) ReturnToQueue() {
+	if room.teamQueue != nil {
+		room.teamQueue.Restore(room.Teams)
+	}
+	if room.queue != nil {
+		room.queue.Restore(room.queueEntries, room.queuePositions)
+	}
+}
+
+// QueueEntries gets a copy of the queue entries the room was filled from.
+// Rooms that were not filled from a player queue have none.
+func (room *Room) QueueEntries() []QueueEntry {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	entries := make([]QueueEntry, 0, len(room.queueEntries))
+	for _, e := range room.queueEntries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// Maps gets the set of games to play in the room, or nil if there is no set.
+func (room *Room) Maps() []MapPick {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	return room.maps
+}
+
+// SetMaps changes the set of games to play in the room.
+func (room *Room) SetMaps(maps []MapPick) {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	room.maps = maps
+}
+
+// PickBan gets the pick/ban set being played in the room, or nil if there is none.
+func (room *Room) PickBan() *PickBan {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	return room.pickBan
+}
+
+// SetPickBan changes the pick/ban set being played in the room.
+func (room *Room) SetPickBan(pickBan *PickBan) {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	room.pickBan = pickBan
+}
+
+// ReportResult marks the room's game as reported. Returns false if a result has already been reported.
+func (room *Room) ReportResult() bool {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if room.reported {
+		return false
+	}
+	room.reported = true
+	return true
+}
+
+// PlayerTeam gets the team a player is playing for in the room, or nil if the room has no teams or the player is not in one.
+func (room *Room) PlayerTeam(player *Player) *QueuedTeam {
+	for _, team := range room.Teams {
+		for _, p := range team.Players {
+			if p == player {
+				return team
+			}
 		}
-		session.GuildMemberRoleAdd(guildID, player.ID, RoleInProgress)
 	}
+	return nil
+}
+
+// channelSpec describes a channel to create for a room.
+type channelSpec struct {
+	name        string
+	channelType string
+	// players who can view the channel. If nil, every player in the room can view it.
+	players []*Player
+}
+
+// permissionOverwrites gets the permissions for a channel in the room.
+// Only the given players, the bot, and moderators can view the channel.
+func (room *Room) permissionOverwrites(session *discordgo.Session, guildID string, players []*Player) []*PermissionOverwrite {
+	var overwrites []*PermissionOverwrite
+	for _, id := range RoleIDs(session, guildID, "@everyone") {
+		overwrites = append(overwrites, &PermissionOverwrite{ID: id, Type: "role", Deny: PermissionView})
+	}
+	for _, player := range players {
+		overwrites = append(overwrites, &PermissionOverwrite{ID: player.ID, Type: "member", Allow: PermissionView})
+	}
+	overwrites = append(overwrites, &PermissionOverwrite{ID: session.State.User.ID, Type: "member", Allow: PermissionView})
+	for _, id := range RoleIDs(session, guildID, "Moderator") {
+		overwrites = append(overwrites, &PermissionOverwrite{ID: id, Type: "role", Allow: PermissionView})
+	}
+	return overwrites
 }
 
 // createChannel creates a channel for the room.
 // session     : Discord session
+// guildID     : The ID of the guild
 // name        : Name of the channel
 // channelType : Type of channel. Valid parameters are "text" or "voice"
 // categoryID  : Category to place the channel under.
-func (room *Room) createChannel(session *discordgo.Session, name, channelType, categoryID string) *discordgo.Channel {
-	guildID := GetGuildID(session)
-	channel, err := GuildChannelCreateWithParentID(session, guildID, name, channelType, categoryID)
+// overwrites  : Permissions for the channel
+func (room *Room) createChannel(session *discordgo.Session, guildID, name, channelType, categoryID string, overwrites []*PermissionOverwrite) (*discordgo.Channel, error) {
+	channel, err := GuildChannelCreateWithParentID(session, guildID, name, channelType, categoryID, overwrites)
 	if err != nil {
-		fmt.Println("Error occurred while creating channel: ", err)
-		return nil
+		return nil, fmt.Errorf("could not create channel %s: %v", name, err)
 	}
 
-	// Remove permission from everyone else
-	SetRolePermission(session, channel.ID, "@everyone", 0, 1024)
-	// Add permissions for the players, bot, and moderators
-	for _, player := range room.Players {
-		session.ChannelPermissionSet(channel.ID, player.ID, "member", 1024, 0)
+	return channel, nil
+}
+
+// createCategory creates a category for the room.
+// session     : Discord session
+// guildID     : The ID of the guild
+// overwrites  : Permissions for the category
+func (room *Room) createCategory(session *discordgo.Session, guildID string, overwrites []*PermissionOverwrite) (*discordgo.Channel, error) {
+	channel, err := GuildChannelCreateCategory(session, guildID, fmt.Sprintf("Match %d", room.ID), overwrites)
+	if err != nil {
+		return nil, fmt.Errorf("could not create category: %v", err)
 	}
-	session.ChannelPermissionSet(channel.ID, session.State.User.ID, "member", 1024, 0)
-	SetRolePermission(session, channel.ID, "Moderator", 1024, 0)
 
-	room.Channels = append(room.Channels, channel.ID)
-	if channelType == "text" {
-		room.TextChannel = channel.ID
+	return channel, nil
+}
+
+// sendIntroMessage outputs the players and their friend codes to the room.
+func (room *Room) sendIntroMessage(session *discordgo.Session, channelID string) error {
+	msg := "Players:"
+	if room.Teams != nil {
+		msg = ""
+		for _, team := range room.Teams {
+			msg += team.Team.Name + ":"
+			for _, player := range team.Players {
+				msg += "\n" + playerLine(player)
+			}
+			msg += "\n"
+		}
+		msg += Commands("Captains, report each game with \"!result win\" or \"!result loss\".")
 	} else {
-		room.VoiceChannels = append(room.VoiceChannels, channel.ID)
+		for _, player := range room.Players {
+			msg += "\n" + playerLine(player)
+		}
+	}
+	if room.Mode != "" {
+		msg += "\n\nMode: " + room.Mode
 	}
+	if maps := room.Maps(); maps != nil {
+		msg += "\n\n" + FormatMapList(maps)
+	}
+	msg += Commands("\nType \"!leave\" to leave the room when you are finished.\nGL HF!")
 
-	return channel
+	_, err := session.ChannelMessageSend(channelID, msg)
+	return err
 }
 
-// createCategory creates a category for the room.
-// session     : Discord session
-func (room *Room) createCategory(session *discordgo.Session) *discordgo.Channel {
-	guildID := GetGuildID(session)
-	channel, err := GuildChannelCreateCategory(session, guildID, "Match")
-	if err != nil {
-		fmt.Println("Error occurred while creating category: ", err)
-		return nil
+// playerLine describes a player in the intro message, with their friend code and region.
+func playerLine(player *Player) string {
+	line := "<@" + player.ID + "> - " + player.FriendCode
+	if region := player.Region(); region != "" {
+		line += " (" + region + ")"
 	}
+	return line
+}
 
-	// Remove permission from everyone else
-	SetRolePermission(session, channel.ID, "@everyone", 0, 1024)
-	// Add permissions for the players, bot, and moderators
-	for _, player := range room.Players {
-		session.ChannelPermissionSet(channel.ID, player.ID, "member", 1024, 0)
+// GrantAccess gives a player permission to view the room's channels.
+func (room *Room) GrantAccess(session *discordgo.Session, player *Player) {
+	for _, c := range room.Channels {
+		if err := session.ChannelPermissionSet(c, player.ID, "member", PermissionView, 0); err != nil {
+			room.Logger().Warn("Error occurred while granting room access", "player", player.ID, "channel", c, "err", err)
+		}
+	}
+}
+
+// RevokeAccess removes a player's permission to view the room's channels.
+func (room *Room) RevokeAccess(session *discordgo.Session, player *Player) {
+	for _, c := range room.Channels {
+		if err := session.ChannelPermissionSet(c, player.ID, "member", 0, PermissionView); err != nil {
+			room.Logger().Warn("Error occurred while revoking room access", "player", player.ID, "channel", c, "err", err)
+		}
 	}
-	session.ChannelPermissionSet(channel.ID, session.State.User.ID, "member", 1024, 0)
-	SetRolePermission(session, channel.ID, "Moderator", 1024, 0)
+}
 
-	room.Channels = append(room.Channels, channel.ID)
+// Cleanup closes the room after a delay, warning the players 5 minutes and 1 minute before it closes.
+func (room *Room) Cleanup(session *discordgo.Session, reason string, delay time.Duration) {
+	session.ChannelMessageSend(room.TextChannel, fmt.Sprintf("%s The room will be closed in %s.", reason, formatDelay(delay)))
+	for _, warning := range []time.Duration{5 * time.Minute, time.Minute} {
+		if delay <= warning {
+			continue
+		}
+		time.Sleep(delay - warning)
+		delay = warning
+		session.ChannelMessageSend(room.TextChannel, fmt.Sprintf("Room will be closed in %s.", formatDelay(delay)))
+	}
+	time.Sleep(delay)
+	room.Close(session)
+}
 
-	return channel
+// formatDelay describes a delay in whole minutes, such as "10 minutes", or in seconds if it is shorter than a minute.
+func formatDelay(delay time.Duration) string {
+	switch minutes := int(delay / time.Minute); {
+	case minutes == 1:
+		return "1 minute"
+	case minutes > 1:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return fmt.Sprintf("%d seconds", int(delay/time.Second))
 }
 
-// sendIntroMessage outputs the players and their friend codes to the room.
-func (room *Room) sendIntroMessage(session *discordgo.Session, channelID string) {
-	msg := "Players:"
-	for _, player := range room.Players {
-		msg += "\n<@" + player.ID + "> - " + player.FriendCode
+// StartCleanup marks the room as being cleaned up. Returns false if its cleanup has already started.
+func (room *Room) StartCleanup() bool {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if room.cleaning {
+		return false
 	}
-	msg += "\nType \"!leave\" to leave the room when you are finished.\nGL HF!"
+	room.cleaning = true
+	return true
+}
+
+// Close deletes the room's channels immediately. Closing a room more than once has no effect.
+func (room *Room) Close(session *discordgo.Session) {
+	lock := guildLock(GetGuildID(session))
+	lock.Lock()
+	defer lock.Unlock()
 
-	session.ChannelMessageSend(channelID, msg)
+	if room.Closed {
+		return
+	}
+	room.Closed = true
+	room.deleteChannels(session)
 }
 
-// Cleanup deletes a channel after 10 minutes.
-func (room *Room) Cleanup(session *discordgo.Session) {
-	session.ChannelMessageSend(room.TextChannel, "A player has left. The room will be closed in 10 minutes.")
-	time.Sleep(5 * time.Minute)
-	session.ChannelMessageSend(room.TextChannel, "Room will be closed in 5 minutes.")
-	time.Sleep(4 * time.Minute)
-	session.ChannelMessageSend(room.TextChannel, "Room will be closed in 1 minute.")
-	time.Sleep(1 * time.Minute)
-	channelMutex.Lock()
+// deleteChannels deletes all of the room's channels.
+func (room *Room) deleteChannels(session *discordgo.Session) {
+	var wg sync.WaitGroup
 	for _, c := range room.Channels {
-		session.ChannelDelete(c)
+		wg.Add(1)
+		go func(c string) {
+			defer wg.Done()
+			if _, err := session.ChannelDelete(c); err != nil {
+				room.Logger().Warn("Error occurred while deleting room channel", "channel", c, "err", err)
+			}
+		}(c)
 	}
-	channelMutex.Unlock()
+	wg.Wait()
 }
@@ -0,0 +1,150 @@
+package pickup
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// ScheduledEvent is a planned session, such as a weekly private battle night, that players sign up for ahead of time.
+type ScheduledEvent struct {
+	ID        int
+	Name      string
+	Start     time.Time
+	QueueType int
+	Capacity  int
+	CreatorID string
+	// Reminders is how many reminders have been sent
+	Reminders int
+	Started   bool
+}
+
+// ScheduleStore is an interface for structs that can store scheduled events and their sign ups
+type ScheduleStore interface {
+	CreateEvent(name string, start time.Time, queueType int, capacity int, creatorID string) (*ScheduledEvent, error)
+	GetEvent(id int) *ScheduledEvent
+	GetUpcomingEvents() []*ScheduledEvent
+	CancelEvent(id int)
+	SetReminders(id int, reminders int)
+	SetStarted(id int)
+	SignUp(id int, playerID string) error
+	Withdraw(id int, playerID string)
+	GetSignups(id int) []string
+}
+
+// SQLiteScheduleStore implements ScheduleStore and uses a SQLite database to store scheduled events
+type SQLiteScheduleStore struct {
+	DB *sql.DB
+}
+
+func (ss SQLiteScheduleStore) CreateEvent(name string, start time.Time, queueType int, capacity int, creatorID string) (*ScheduledEvent, error) {
+	result, err := ss.DB.Exec("INSERT INTO ScheduledEvents (Name, Start, QueueType, Capacity, CreatorID) VALUES (?, ?, ?, ?, ?)",
+		name, start.Unix(), queueType, capacity, creatorID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledEvent{ID: int(id), Name: name, Start: start, QueueType: queueType, Capacity: capacity, CreatorID: creatorID}, nil
+}
+
+func (ss SQLiteScheduleStore) GetEvent(id int) *ScheduledEvent {
+	rows, err := ss.DB.Query("SELECT ID, Name, Start, QueueType, Capacity, CreatorID, Reminders, Started FROM ScheduledEvents WHERE ID = ?", id)
+	if err != nil {
+		slog.Error("Error occurred while getting event", "event", id, "err", err)
+		return nil
+	}
+	defer rows.Close()
+
+	if events := scanEvents(rows); len(events) > 0 {
+		return events[0]
+	}
+	return nil
+}
+
+// GetUpcomingEvents returns the events that have not started, soonest first.
+func (ss SQLiteScheduleStore) GetUpcomingEvents() []*ScheduledEvent {
+	rows, err := ss.DB.Query("SELECT ID, Name, Start, QueueType, Capacity, CreatorID, Reminders, Started FROM ScheduledEvents WHERE Started = 0 ORDER BY Start")
+	if err != nil {
+		slog.Error("Error occurred while getting upcoming events", "err", err)
+		return nil
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) []*ScheduledEvent {
+	var events []*ScheduledEvent
+	for rows.Next() {
+		var start int64
+		event := new(ScheduledEvent)
+		if err := rows.Scan(&event.ID, &event.Name, &start, &event.QueueType, &event.Capacity, &event.CreatorID, &event.Reminders, &event.Started); err != nil {
+			slog.Error("Error occurred while reading event", "err", err)
+			continue
+		}
+		event.Start = time.Unix(start, 0)
+		events = append(events, event)
+	}
+	return events
+}
+
+func (ss SQLiteScheduleStore) CancelEvent(id int) {
+	tx, err := ss.DB.Begin()
+	if err != nil {
+		slog.Error("Error occurred while cancelling event", "event", id, "err", err)
+		return
+	}
+	tx.Exec("DELETE FROM EventSignups WHERE EventID = ?", id)
+	tx.Exec("DELETE FROM ScheduledEvents WHERE ID = ?", id)
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error occurred while cancelling event", "event", id, "err", err)
+	}
+}
+
+func (ss SQLiteScheduleStore) SetReminders(id int, reminders int) {
+	_, err := ss.DB.Exec("UPDATE ScheduledEvents SET Reminders = ? WHERE ID = ?", reminders, id)
+	if err != nil {
+		slog.Error("Error occurred while saving event reminders", "event", id, "err", err)
+	}
+}
+
+func (ss SQLiteScheduleStore) SetStarted(id int) {
+	_, err := ss.DB.Exec("UPDATE ScheduledEvents SET Started = 1 WHERE ID = ?", id)
+	if err != nil {
+		slog.Error("Error occurred while starting event", "event", id, "err", err)
+	}
+}
+
+func (ss SQLiteScheduleStore) SignUp(id int, playerID string) error {
+	_, err := ss.DB.Exec("INSERT INTO EventSignups (EventID, DiscordID, SignedUp) VALUES (?, ?, ?)", id, playerID, time.Now().UnixNano())
+	return err
+}
+
+func (ss SQLiteScheduleStore) Withdraw(id int, playerID string) {
+	_, err := ss.DB.Exec("DELETE FROM EventSignups WHERE EventID = ? AND DiscordID = ?", id, playerID)
+	if err != nil {
+		slog.Error("Error occurred while withdrawing from event", "event", id, "player", playerID, "err", err)
+	}
+}
+
+// GetSignups returns the players signed up for an event, in the order they signed up.
+func (ss SQLiteScheduleStore) GetSignups(id int) []string {
+	var ids []string
+	rows, err := ss.DB.Query("SELECT DiscordID FROM EventSignups WHERE EventID = ? ORDER BY SignedUp", id)
+	if err != nil {
+		slog.Error("Error occurred while getting event signups", "event", id, "err", err)
+		return ids
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playerID string
+		rows.Scan(&playerID)
+		ids = append(ids, playerID)
+	}
+	return ids
+}
@@ -0,0 +1,245 @@
+package pickup
+
+import (
+	"database/sql"
+	"log/slog"
+	"math"
+)
+
+// ScrimTeamSize is the number of players in a team for scrims.
+const ScrimTeamSize = 4
+
+// StartingRating is the rating a new team starts with.
+const StartingRating = 1000
+
+// ratingK is the most a team's rating can change after a single game.
+const ratingK = 32
+
+// Team is a named group of players led by a captain.
+type Team struct {
+	ID        int
+	Name      string
+	Tag       string
+	CaptainID string
+	Members   []string
+	Wins      int
+	Losses    int
+	Rating    int
+}
+
+// HasMember checks if a player is a member of the team.
+func (team *Team) HasMember(id string) bool {
+	for _, m := range team.Members {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RatingChange gets how many points the winner of a game gains and the loser loses, using the Elo rating system.
+func RatingChange(winnerRating int, loserRating int) int {
+	expected := 1 / (1 + math.Pow(10, float64(loserRating-winnerRating)/400))
+	return int(math.Round(ratingK * (1 - expected)))
+}
+
+// TeamStore is an interface for structs that can store teams
+type TeamStore interface {
+	CreateTeam(name string, tag string, captainID string) (*Team, error)
+	TeamExists(name string, tag string) bool
+	GetTeam(id int) *Team
+	GetTeamByTag(tag string) *Team
+	GetPlayerTeam(playerID string) *Team
+	GetTeams() []*Team
+	AddMember(teamID int, playerID string) error
+	RemoveMember(teamID int, playerID string)
+	DeleteTeam(teamID int)
+	Invite(teamID int, playerID string)
+	HasInvite(teamID int, playerID string) bool
+	RecordMatch(winnerID int, loserID int)
+}
+
+// SQLiteTeamStore implements TeamStore and uses a SQLite database to store teams
+type SQLiteTeamStore struct {
+	DB *sql.DB
+}
+
+// CreateTeam creates a team with the captain as its only member.
+func (ts SQLiteTeamStore) CreateTeam(name string, tag string, captainID string) (*Team, error) {
+	tx, err := ts.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec("INSERT INTO Teams (Name, Tag, CaptainID, Wins, Losses, Rating) VALUES (?, ?, ?, 0, 0, ?)", name, tag, captainID, StartingRating)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO TeamMembers (TeamID, DiscordID) VALUES (?, ?)", id, captainID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ts.GetTeam(int(id)), nil
+}
+
+// TeamExists checks if a team already uses the name or the tag.
+func (ts SQLiteTeamStore) TeamExists(name string, tag string) bool {
+	var count int
+	err := ts.DB.QueryRow("SELECT COUNT(*) FROM Teams WHERE Name = ? COLLATE NOCASE OR Tag = ? COLLATE NOCASE", name, tag).Scan(&count)
+	if err != nil {
+		slog.Error("Error occurred while checking team", "name", name, "tag", tag, "err", err)
+	}
+	return count > 0
+}
+
+// GetTeam gets a team by its ID, or nil if it does not exist.
+func (ts SQLiteTeamStore) GetTeam(id int) *Team {
+	return ts.queryTeam("SELECT ID, Name, Tag, CaptainID, Wins, Losses, Rating FROM Teams WHERE ID = ?", id)
+}
+
+// GetTeamByTag gets a team by its tag, or nil if it does not exist.
+func (ts SQLiteTeamStore) GetTeamByTag(tag string) *Team {
+	return ts.queryTeam("SELECT ID, Name, Tag, CaptainID, Wins, Losses, Rating FROM Teams WHERE Tag = ? COLLATE NOCASE", tag)
+}
+
+// GetPlayerTeam gets the team a player is a member of, or nil if they are not in a team.
+func (ts SQLiteTeamStore) GetPlayerTeam(playerID string) *Team {
+	return ts.queryTeam(`SELECT t.ID, t.Name, t.Tag, t.CaptainID, t.Wins, t.Losses, t.Rating FROM Teams t
+		JOIN TeamMembers m ON m.TeamID = t.ID WHERE m.DiscordID = ?`, playerID)
+}
+
+// GetTeams gets every team, ordered from the highest rating to the lowest.
+func (ts SQLiteTeamStore) GetTeams() []*Team {
+	var teams []*Team
+	rows, err := ts.DB.Query("SELECT ID FROM Teams ORDER BY Rating DESC")
+	if err != nil {
+		slog.Error("Error occurred while getting teams", "err", err)
+		return teams
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if team := ts.GetTeam(id); team != nil {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+// queryTeam gets a single team and its members, or nil if the query finds no team.
+func (ts SQLiteTeamStore) queryTeam(query string, args ...interface{}) *Team {
+	team := new(Team)
+	err := ts.DB.QueryRow(query, args...).Scan(&team.ID, &team.Name, &team.Tag, &team.CaptainID, &team.Wins, &team.Losses, &team.Rating)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Error occurred while getting team", "err", err)
+		}
+		return nil
+	}
+
+	rows, err := ts.DB.Query("SELECT DiscordID FROM TeamMembers WHERE TeamID = ? ORDER BY rowid", team.ID)
+	if err != nil {
+		slog.Error("Error occurred while getting team members", "team", team.ID, "err", err)
+		return team
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member string
+		rows.Scan(&member)
+		team.Members = append(team.Members, member)
+	}
+	return team
+}
+
+// AddMember adds a player to a team and removes their invite.
+func (ts SQLiteTeamStore) AddMember(teamID int, playerID string) error {
+	_, err := ts.DB.Exec("INSERT INTO TeamMembers (TeamID, DiscordID) VALUES (?, ?)", teamID, playerID)
+	if err != nil {
+		return err
+	}
+	_, err = ts.DB.Exec("DELETE FROM TeamInvites WHERE TeamID = ? AND DiscordID = ?", teamID, playerID)
+	return err
+}
+
+func (ts SQLiteTeamStore) RemoveMember(teamID int, playerID string) {
+	_, err := ts.DB.Exec("DELETE FROM TeamMembers WHERE TeamID = ? AND DiscordID = ?", teamID, playerID)
+	if err != nil {
+		slog.Error("Error occurred while removing team member", "team", teamID, "player", playerID, "err", err)
+	}
+}
+
+// DeleteTeam deletes a team along with its members and invites.
+func (ts SQLiteTeamStore) DeleteTeam(teamID int) {
+	for _, query := range []string{
+		"DELETE FROM TeamInvites WHERE TeamID = ?",
+		"DELETE FROM TeamMembers WHERE TeamID = ?",
+		"DELETE FROM Teams WHERE ID = ?",
+	} {
+		_, err := ts.DB.Exec(query, teamID)
+		if err != nil {
+			slog.Error("Error occurred while deleting team", "team", teamID, "err", err)
+		}
+	}
+}
+
+func (ts SQLiteTeamStore) Invite(teamID int, playerID string) {
+	_, err := ts.DB.Exec("INSERT OR IGNORE INTO TeamInvites (TeamID, DiscordID) VALUES (?, ?)", teamID, playerID)
+	if err != nil {
+		slog.Error("Error occurred while inviting player", "team", teamID, "player", playerID, "err", err)
+	}
+}
+
+func (ts SQLiteTeamStore) HasInvite(teamID int, playerID string) bool {
+	var count int
+	err := ts.DB.QueryRow("SELECT COUNT(*) FROM TeamInvites WHERE TeamID = ? AND DiscordID = ?", teamID, playerID).Scan(&count)
+	if err != nil {
+		slog.Error("Error occurred while checking invite", "team", teamID, "player", playerID, "err", err)
+	}
+	return count > 0
+}
+
+// RecordMatch records a game between two teams, updating their wins, losses and ratings.
+func (ts SQLiteTeamStore) RecordMatch(winnerID int, loserID int) {
+	winner := ts.GetTeam(winnerID)
+	loser := ts.GetTeam(loserID)
+	if winner == nil || loser == nil {
+		return
+	}
+	change := RatingChange(winner.Rating, loser.Rating)
+
+	tx, err := ts.DB.Begin()
+	if err != nil {
+		slog.Error("Error occurred while recording match", "winner", winnerID, "loser", loserID, "err", err)
+		return
+	}
+	_, err = tx.Exec("UPDATE Teams SET Wins = Wins + 1, Rating = Rating + ? WHERE ID = ?", change, winnerID)
+	if err == nil {
+		_, err = tx.Exec("UPDATE Teams SET Losses = Losses + 1, Rating = Rating - ? WHERE ID = ?", change, loserID)
+	}
+	if err != nil {
+		slog.Error("Error occurred while recording match", "winner", winnerID, "loser", loserID, "err", err)
+		tx.Rollback()
+		return
+	}
+	tx.Commit()
+}
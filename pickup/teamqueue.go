@@ -0,0 +1,170 @@
+package pickup
+
+import (
+	"sync"
+	"time"
+)
+
+// QueuedTeam is a team waiting in a TeamQueue along with the players that are playing for it.
+type QueuedTeam struct {
+	Team    *Team
+	Players []*Player
+	// Active is when the team joined the queue or its captain last confirmed it is still searching.
+	Active time.Time
+}
+
+// canPlayAgainst checks that nobody on either team is avoiding anybody on the other team.
+func (qt *QueuedTeam) canPlayAgainst(other *QueuedTeam) bool {
+	for _, p := range qt.Players {
+		for _, o := range other.Players {
+			if !p.CanPlayWith(o) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TeamQueue is a queue of teams waiting to play against another team.
+type TeamQueue struct {
+	Teams []*QueuedTeam
+	mutex sync.Mutex
+}
+
+// Enqueue adds a team to the queue. If another team in the queue can play against it, a room is created for both teams and returned.
+func (queue *TeamQueue) Enqueue(team *QueuedTeam) *Room {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	team.Active = time.Now()
+	for i, opponent := range queue.Teams {
+		if !team.canPlayAgainst(opponent) {
+			continue
+		}
+
+		copy(queue.Teams[i:], queue.Teams[i+1:])
+		queue.Teams[len(queue.Teams)-1] = nil
+		queue.Teams = queue.Teams[:len(queue.Teams)-1]
+
+		room := NewTeamRoom(opponent, team)
+		room.teamQueue = queue
+		return room
+	}
+
+	queue.Teams = append(queue.Teams, team)
+	return nil
+}
+
+// NewTeamRoom creates a room for teams to play against each other.
+func NewTeamRoom(teams ...*QueuedTeam) *Room {
+	room := new(Room)
+	room.ID = nextRoomID()
+	room.Teams = teams
+	for _, team := range teams {
+		room.Size += len(team.Players)
+		for _, p := range team.Players {
+			room.AddPlayer(p)
+		}
+	}
+	return room
+}
+
+// Restore puts teams back at the front of the queue, in order.
+func (queue *TeamQueue) Restore(teams []*QueuedTeam) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.Teams = append(append([]*QueuedTeam(nil), teams...), queue.Teams...)
+}
+
+// RemovePlayer removes the team a player is playing for from the queue and returns it, or nil if the player is not in the queue.
+func (queue *TeamQueue) RemovePlayer(player *Player) *QueuedTeam {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for i, team := range queue.Teams {
+		for _, p := range team.Players {
+			if p == player {
+				copy(queue.Teams[i:], queue.Teams[i+1:])
+				queue.Teams[len(queue.Teams)-1] = nil
+				queue.Teams = queue.Teams[:len(queue.Teams)-1]
+				return team
+			}
+		}
+	}
+	return nil
+}
+
+// Contains checks if a player's team is in the queue.
+func (queue *TeamQueue) Contains(player *Player) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for _, team := range queue.Teams {
+		for _, p := range team.Players {
+			if p == player {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Clear removes every team from the queue and returns the removed teams.
+func (queue *TeamQueue) Clear() []*QueuedTeam {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	teams := queue.Teams
+	queue.Teams = nil
+	return teams
+}
+
+// Snapshot returns a copy of the teams currently in the queue.
+func (queue *TeamQueue) Snapshot() []*QueuedTeam {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return append([]*QueuedTeam(nil), queue.Teams...)
+}
+
+// Len returns the number of teams in the queue.
+func (queue *TeamQueue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return len(queue.Teams)
+}
+
+// Stale gets the captains of the teams that have not been active for longer than maxAge.
+func (queue *TeamQueue) Stale(maxAge time.Duration) []*Player {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var captains []*Player
+	for _, team := range queue.Teams {
+		if time.Since(team.Active) <= maxAge {
+			continue
+		}
+		for _, p := range team.Players {
+			if p.ID == team.Team.CaptainID {
+				captains = append(captains, p)
+			}
+		}
+	}
+	return captains
+}
+
+// Refresh marks the team a player is playing for as active, so it is not stale.
+func (queue *TeamQueue) Refresh(player *Player) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for _, team := range queue.Teams {
+		for _, p := range team.Players {
+			if p == player {
+				team.Active = time.Now()
+			}
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package pickup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// SingleElimination is a bracket where teams are out after one loss
+	SingleElimination = iota
+	// DoubleElimination is a bracket where teams are out after two losses
+	DoubleElimination
+)
+
+const (
+	// WinnersBracket holds the matches of teams that have not lost yet
+	WinnersBracket = iota
+	// LosersBracket holds the matches of teams that have lost once in a double elimination tournament
+	LosersBracket
+	// GrandFinal is the match between the winners of the winners and losers brackets
+	GrandFinal
+)
+
+// BracketMatch is a match between two teams in a tournament bracket.
+type BracketMatch struct {
+	ID      int
+	Bracket int
+	Round   int
+	Teams   [2]*Team
+	Winner  *Team
+	Loser   *Team
+	Done    bool
+	RoomID  int
+
+	// claimed is true once a room is being created for the match
+	claimed bool
+
+	// ready is true for each slot once its team is known. A ready slot without a team is a bye.
+	ready    [2]bool
+	winnerTo *BracketMatch
+	winSlot  int
+	loserTo  *BracketMatch
+	loseSlot int
+}
+
+// Playable checks if both teams of the match are known and the match has not been played.
+func (match *BracketMatch) Playable() bool {
+	return !match.Done && match.Teams[0] != nil && match.Teams[1] != nil
+}
+
+// Tournament is a bracket tournament between teams.
+type Tournament struct {
+	Name    string
+	Format  int
+	Teams   []*Team
+	Matches []*BracketMatch
+	Started bool
+	mutex   sync.Mutex
+}
+
+// NewTournament creates a tournament that is open for sign ups.
+func NewTournament(name string, format int) *Tournament {
+	return &Tournament{Name: name, Format: format}
+}
+
+// SignUp adds a team to the tournament.
+func (t *Tournament) SignUp(team *Team) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.Started {
+		return errors.New("the tournament has already started")
+	}
+	for _, other := range t.Teams {
+		if other.ID == team.ID {
+			return errors.New("the team has already signed up")
+		}
+	}
+	t.Teams = append(t.Teams, team)
+	return nil
+}
+
+// Withdraw removes a team from the tournament before it starts.
+func (t *Tournament) Withdraw(teamID int) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.Started {
+		return errors.New("the tournament has already started")
+	}
+	for i, team := range t.Teams {
+		if team.ID == teamID {
+			t.Teams = append(t.Teams[:i], t.Teams[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("the team has not signed up")
+}
+
+// Start seeds the teams by rating and generates the bracket.
+func (t *Tournament) Start() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.Started {
+		return errors.New("the tournament has already started")
+	}
+	if len(t.Teams) < 2 {
+		return errors.New("at least two teams must sign up")
+	}
+
+	sort.SliceStable(t.Teams, func(i, j int) bool { return t.Teams[i].Rating > t.Teams[j].Rating })
+
+	size := 2
+	for size < len(t.Teams) {
+		size *= 2
+	}
+
+	// Place the seeds so the best teams meet as late as possible, giving byes to the top seeds
+	var first []*BracketMatch
+	seeds := seedOrder(size)
+	for i := 0; i < size; i += 2 {
+		match := t.addMatch(WinnersBracket, 1)
+		for slot := 0; slot < 2; slot++ {
+			if seed := seeds[i+slot]; seed <= len(t.Teams) {
+				match.Teams[slot] = t.Teams[seed-1]
+			}
+			match.ready[slot] = true
+		}
+		first = append(first, match)
+	}
+
+	winners := t.buildWinnersBracket(first)
+	if t.Format == DoubleElimination {
+		champion := t.buildLosersBracket(winners)
+		final := t.addMatch(GrandFinal, 1)
+		last := winners[len(winners)-1][0]
+		last.winnerTo, last.winSlot = final, 0
+		champion.winnerTo, champion.winSlot = final, 1
+
+		// If the team from the losers bracket wins the grand final, both teams have lost once and play again
+		reset := t.addMatch(GrandFinal, 2)
+		final.winnerTo, final.winSlot = reset, 0
+		final.loserTo, final.loseSlot = reset, 1
+	}
+
+	t.Started = true
+	for _, match := range first {
+		t.settle(match)
+	}
+	return nil
+}
+
+// buildWinnersBracket links the first round into a full elimination bracket and returns the matches of each round.
+func (t *Tournament) buildWinnersBracket(first []*BracketMatch) [][]*BracketMatch {
+	rounds := [][]*BracketMatch{first}
+	for round := first; len(round) > 1; {
+		var next []*BracketMatch
+		for i := 0; i < len(round); i += 2 {
+			match := t.addMatch(WinnersBracket, len(rounds)+1)
+			round[i].winnerTo, round[i].winSlot = match, 0
+			round[i+1].winnerTo, round[i+1].winSlot = match, 1
+			next = append(next, match)
+		}
+		rounds = append(rounds, next)
+		round = next
+	}
+	return rounds
+}
+
+// buildLosersBracket creates the losers bracket fed by the winners bracket rounds and returns its final match.
+func (t *Tournament) buildLosersBracket(winners [][]*BracketMatch) *BracketMatch {
+	// The first losers round pairs up the losers of the first winners round
+	var round []*BracketMatch
+	lround := 1
+	first := winners[0]
+	if len(first) == 1 {
+		// With only two teams, the loser of the only match goes straight to the grand final
+		match := t.addMatch(LosersBracket, lround)
+		first[0].loserTo, first[0].loseSlot = match, 0
+		match.ready[1] = true
+		return match
+	}
+	for i := 0; i < len(first); i += 2 {
+		match := t.addMatch(LosersBracket, lround)
+		first[i].loserTo, first[i].loseSlot = match, 0
+		first[i+1].loserTo, first[i+1].loseSlot = match, 1
+		round = append(round, match)
+	}
+
+	for w := 1; w < len(winners); w++ {
+		// Survivors of the losers bracket play the losers dropping down from the winners bracket
+		lround++
+		var dropped []*BracketMatch
+		for i, match := range round {
+			next := t.addMatch(LosersBracket, lround)
+			match.winnerTo, match.winSlot = next, 0
+			// Losers drop in reverse order so teams do not meet again straight away
+			source := winners[w][len(winners[w])-1-i]
+			source.loserTo, source.loseSlot = next, 1
+			dropped = append(dropped, next)
+		}
+		round = dropped
+
+		if len(round) == 1 {
+			break
+		}
+
+		// Then the survivors play each other
+		lround++
+		var next []*BracketMatch
+		for i := 0; i < len(round); i += 2 {
+			match := t.addMatch(LosersBracket, lround)
+			round[i].winnerTo, round[i].winSlot = match, 0
+			round[i+1].winnerTo, round[i+1].winSlot = match, 1
+			next = append(next, match)
+		}
+		round = next
+	}
+	return round[0]
+}
+
+// addMatch adds an empty match to the bracket.
+func (t *Tournament) addMatch(bracket int, round int) *BracketMatch {
+	match := &BracketMatch{ID: len(t.Matches) + 1, Bracket: bracket, Round: round}
+	t.Matches = append(t.Matches, match)
+	return match
+}
+
+// seedOrder gets the seed in each slot of the first round, so that seed 1 and seed 2 can only meet in the final.
+func seedOrder(size int) []int {
+	order := []int{1, 2}
+	for len(order) < size {
+		var next []int
+		for _, seed := range order {
+			next = append(next, seed, len(order)*2+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// Report records the winner of a match and advances the bracket.
+// Returns the matches that became playable as a result.
+func (t *Tournament) Report(matchID int, winnerID int) ([]*BracketMatch, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if matchID < 1 || matchID > len(t.Matches) {
+		return nil, fmt.Errorf("match %d does not exist", matchID)
+	}
+	match := t.Matches[matchID-1]
+	if !match.Playable() {
+		return nil, fmt.Errorf("match %d cannot be reported", matchID)
+	}
+
+	switch winnerID {
+	case match.Teams[0].ID:
+		match.Winner, match.Loser = match.Teams[0], match.Teams[1]
+	case match.Teams[1].ID:
+		match.Winner, match.Loser = match.Teams[1], match.Teams[0]
+	default:
+		return nil, fmt.Errorf("the team is not playing in match %d", matchID)
+	}
+	match.Done = true
+
+	before := t.playable()
+	t.advance(match)
+
+	var opened []*BracketMatch
+	for _, m := range t.playable() {
+		if !containsMatch(before, m) {
+			opened = append(opened, m)
+		}
+	}
+	return opened, nil
+}
+
+// advance moves the winner and loser of a finished match into their next matches.
+// The grand final reset is only played if the team from the winners bracket lost the grand final.
+func (t *Tournament) advance(match *BracketMatch) {
+	if match.Bracket == GrandFinal && match.Round == 1 && match.Winner == match.Teams[0] {
+		reset := match.winnerTo
+		reset.Teams[0], reset.Winner = match.Winner, match.Winner
+		reset.ready = [2]bool{true, true}
+		reset.Done = true
+		return
+	}
+	if next := match.winnerTo; next != nil {
+		next.Teams[match.winSlot] = match.Winner
+		next.ready[match.winSlot] = true
+		t.settle(next)
+	}
+	if next := match.loserTo; next != nil {
+		next.Teams[match.loseSlot] = match.Loser
+		next.ready[match.loseSlot] = true
+		t.settle(next)
+	}
+}
+
+// settle finishes a match straight away if both of its slots are known and at least one of them is a bye.
+func (t *Tournament) settle(match *BracketMatch) {
+	if match.Done || !match.ready[0] || !match.ready[1] {
+		return
+	}
+	if match.Teams[0] != nil && match.Teams[1] != nil {
+		return
+	}
+
+	match.Winner = match.Teams[0]
+	if match.Winner == nil {
+		match.Winner = match.Teams[1]
+	}
+	match.Done = true
+	t.advance(match)
+}
+
+// Playable gets the matches that are ready to be played.
+func (t *Tournament) Playable() []*BracketMatch {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.playable()
+}
+
+func (t *Tournament) playable() []*BracketMatch {
+	var matches []*BracketMatch
+	for _, match := range t.Matches {
+		if match.Playable() {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// MatchForRoom gets the match being played in a room, or nil if the room is not for this tournament.
+func (t *Tournament) MatchForRoom(roomID int) *BracketMatch {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, match := range t.Matches {
+		if match.RoomID == roomID && !match.Done {
+			return match
+		}
+	}
+	return nil
+}
+
+// ClaimMatch reserves a playable match so that only one room is created for it.
+// Returns false if the match is not playable or already has a room.
+func (t *Tournament) ClaimMatch(matchID int) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	match := t.Matches[matchID-1]
+	if !match.Playable() || match.claimed {
+		return false
+	}
+	match.claimed = true
+	return true
+}
+
+// Unclaim lets a room be created again for a match whose room could not be set up.
+func (t *Tournament) Unclaim(matchID int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.Matches[matchID-1].claimed = false
+}
+
+// SetRoom records the room a match is being played in.
+func (t *Tournament) SetRoom(matchID int, roomID int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.Matches[matchID-1].RoomID = roomID
+}
+
+// MatchRoom gets the ID of the room a match was played in, or 0 if it has none.
+func (t *Tournament) MatchRoom(matchID int) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if matchID < 1 || matchID > len(t.Matches) {
+		return 0
+	}
+	return t.Matches[matchID-1].RoomID
+}
+
+// Champion gets the winner of the tournament, or nil if the tournament is not finished.
+func (t *Tournament) Champion() *Team {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.Matches) == 0 {
+		return nil
+	}
+	final := t.Matches[len(t.Matches)-1]
+	if t.Format == SingleElimination {
+		// The final of a single elimination bracket is the last winners bracket match
+		for _, match := range t.Matches {
+			if match.Bracket == WinnersBracket && match.winnerTo == nil {
+				final = match
+			}
+		}
+	}
+	if !final.Done {
+		return nil
+	}
+	return final.Winner
+}
+
+// Render draws the bracket as text.
+func (t *Tournament) Render() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	format := "Single elimination"
+	if t.Format == DoubleElimination {
+		format = "Double elimination"
+	}
+	msg := fmt.Sprintf("**%s** (%s)", t.Name, format)
+
+	if !t.Started {
+		msg += fmt.Sprintf("\nSign ups are open. %d teams:", len(t.Teams))
+		for _, team := range t.Teams {
+			msg += fmt.Sprintf("\n%s [%s] - %d", team.Name, team.Tag, team.Rating)
+		}
+		return msg
+	}
+
+	bracketNames := map[int]string{WinnersBracket: "Winners", LosersBracket: "Losers", GrandFinal: "Grand Final"}
+	lastBracket, lastRound := -1, -1
+	for _, match := range t.Matches {
+		// Matches against a bye are not worth showing
+		if match.Done && (match.Teams[0] == nil || match.Teams[1] == nil) {
+			continue
+		}
+
+		if match.Bracket != lastBracket || match.Round != lastRound {
+			if match.Bracket == GrandFinal && match.Round > 1 {
+				msg += "\n__Grand Final Reset__"
+			} else if match.Bracket == GrandFinal {
+				msg += "\n__Grand Final__"
+			} else {
+				msg += fmt.Sprintf("\n__%s round %d__", bracketNames[match.Bracket], match.Round)
+			}
+			lastBracket, lastRound = match.Bracket, match.Round
+		}
+
+		msg += fmt.Sprintf("\nMatch %d: %s vs %s", match.ID, bracketTeamName(match, 0), bracketTeamName(match, 1))
+		if match.Done {
+			msg += " - " + match.Winner.Name + " won"
+		}
+	}
+	return msg
+}
+
+// bracketTeamName gets the name of the team in a slot of a match, or TBD if the team is not known yet.
+func bracketTeamName(match *BracketMatch, slot int) string {
+	if match.Teams[slot] != nil {
+		return match.Teams[slot].Name
+	}
+	if match.ready[slot] {
+		return "bye"
+	}
+	return "TBD"
+}
+
+func containsMatch(matches []*BracketMatch, match *BracketMatch) bool {
+	for _, m := range matches {
+		if m == match {
+			return true
+		}
+	}
+	return false
+}
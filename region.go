@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// rematchInterval is how often the queues are checked for players who have waited long enough to match with other regions.
+const rematchInterval = 30 * time.Second
+
+var rematchOnce sync.Once
+
+// regionCommand shows or changes the author's region.
+func regionCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can set your region."), m.Author.ID))
+		return
+	}
+	player := registry.Load(m.Author.ID, playerStore)
+
+	if len(input) < 2 {
+		region := player.Region()
+		if region == "" {
+			region = "not set"
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Your region is %s. Change it with \"!region %s\"."), m.Author.ID, region, strings.ToLower(strings.Join(pickup.Regions, "|"))))
+		return
+	}
+
+	region, ok := pickup.ParseRegion(input[1])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a region. Choose one of %s.", m.Author.ID, input[1], strings.Join(pickup.Regions, ", ")))
+		return
+	}
+
+	playerStore.SetRegion(m.Author.ID, region)
+	player.SetRegion(region)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Your region is now %s. It applies the next time you join a queue.", m.Author.ID, region))
+}
+
+// startRematching starts forming rooms for players who have waited long enough to match with other regions.
+func startRematching(s *discordgo.Session) {
+	rematchOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(rematchInterval)
+				// Without a wait, players from other regions are matched straight away so there is nothing to do
+				if currentConfig().RegionWait <= 0 {
+					continue
+				}
+				for queueType, q := range searchQueues {
+					// One room at a time, so a room that keeps failing to be set up is not retried in a loop
+					if room := q.Rematch(); room != nil {
+						startRoom(s, room, queueType)
+					}
+				}
+			}
+		}()
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// roleReconcileInterval is how often every member's roles are checked against the queues and rooms.
+const roleReconcileInterval = 10 * time.Minute
+
+var reconcileOnce sync.Once
+
+// guildCreate starts reconciling roles once the guild is available.
+func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	reconcileOnce.Do(func() {
+		go func() {
+			for {
+				reconcileRoles(s)
+				time.Sleep(roleReconcileInterval)
+			}
+		}()
+	})
+}
+
+// guildRoleCreate discards the cached roles when a role is created.
+func guildRoleCreate(s *discordgo.Session, r *discordgo.GuildRoleCreate) {
+	pickup.InvalidateRoleCache(r.GuildID)
+}
+
+// guildRoleUpdate discards the cached roles when a role is changed.
+func guildRoleUpdate(s *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+	pickup.InvalidateRoleCache(r.GuildID)
+}
+
+// guildRoleDelete discards the cached roles when a role is deleted.
+func guildRoleDelete(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
+	pickup.InvalidateRoleCache(r.GuildID)
+}
+
+// reconcileRoles removes stale searching and in progress roles, and adds any that are missing.
+func reconcileRoles(s *discordgo.Session) {
+	err := pickup.ReconcileRoles(s, pickup.GetGuildID(s), expectedRoles)
+	if err != nil {
+		slog.Error("Error occurred while reconciling roles", "err", err)
+	}
+}
+
+// expectedRoles computes the roles a player should have from their current state and the queues they are in.
+// Players in a ready check are left alone, as their roles change when the check ends.
+func expectedRoles(id string) ([]string, bool) {
+	switch registry.State(id) {
+	case pickup.StateSearching:
+		p, ok := registry.Get(id)
+		if !ok {
+			return nil, false
+		}
+		var roles []string
+		for _, queueType := range queuesContaining(p) {
+			roles = append(roles, pickup.SearchRole(queueType))
+		}
+		return roles, true
+	case pickup.StateInMatch:
+		return []string{pickup.RoleInProgress}, true
+	case pickup.StateReadyCheck:
+		return nil, false
+	}
+	return nil, true
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// eventTimeLayout is how event start times are written, in the bot's local time zone.
+const eventTimeLayout = "2006-01-02T15:04"
+
+// scheduleCheckInterval is how often scheduled events are checked for reminders and start times.
+const scheduleCheckInterval = time.Minute
+
+// eventReminders are how long before an event starts its players are reminded, from earliest to latest.
+var eventReminders = []time.Duration{24 * time.Hour, time.Hour, 10 * time.Minute}
+
+// eventCreateRegex matches `!event create "<name>" <start> <queue> <capacity>`.
+var eventCreateRegex = regexp.MustCompile(`^!event create\s+"([^"]+)"\s+(\S+)\s+(\S+)\s+(\d+)\s*$`)
+
+var scheduleOnce sync.Once
+
+// eventCommand handles the "!event" commands.
+func eventCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !event create|list|info|signup|leave|cancel"), m.Author.ID))
+		return
+	}
+
+	switch input[1] {
+	case "create":
+		createEvent(s, m)
+	case "list":
+		listEvents(s, m)
+	case "info":
+		if event := parseEvent(s, m, input); event != nil {
+			eventInfo(s, m, event)
+		}
+	case "signup":
+		if event := parseEvent(s, m, input); event != nil {
+			eventSignUp(s, m, event)
+		}
+	case "leave":
+		if event := parseEvent(s, m, input); event != nil {
+			scheduleStore.Withdraw(event.ID, m.Author.ID)
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are no longer signed up for %s.", m.Author.ID, event.Name))
+		}
+	case "cancel":
+		if !pickup.MemberHasRole(s, pickup.GetGuildID(s), m.Author.ID, moderatorRole) {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only moderators can cancel events.", m.Author.ID))
+			return
+		}
+		if event := parseEvent(s, m, input); event != nil {
+			scheduleStore.CancelEvent(event.ID)
+			logModAction(s, pickup.GetGuildID(s), m.Author.ID, "cancelled event "+event.Name, 0, "")
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s has been cancelled.", m.Author.ID, event.Name))
+		}
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Unknown event command \"%s\".", m.Author.ID, input[1]))
+	}
+}
+
+// parseEvent gets the upcoming event whose ID is the third word of a command, telling the author if there is none.
+func parseEvent(s *discordgo.Session, m *discordgo.MessageCreate, input []string) *pickup.ScheduledEvent {
+	if len(input) < 3 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !event %s <event>"), m.Author.ID, input[1]))
+		return nil
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(input[2], "#"))
+	if err == nil {
+		if event := scheduleStore.GetEvent(id); event != nil && !event.Started {
+			return event
+		}
+	}
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no upcoming event %s.", m.Author.ID, input[2]))
+	return nil
+}
+
+// createEvent schedules an event and opens sign ups. Only moderators can create events.
+func createEvent(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := pickup.GetGuildID(s)
+	if !pickup.MemberHasRole(s, guildID, m.Author.ID, moderatorRole) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only moderators can create events.", m.Author.ID))
+		return
+	}
+
+	args := eventCreateRegex.FindStringSubmatch(m.Content)
+	if args == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !event create \"<name>\" <YYYY-MM-DDTHH:MM> pair|quad|private <players>"), m.Author.ID))
+		return
+	}
+
+	start, err := time.ParseInLocation(eventTimeLayout, args[2], time.Local)
+	if err != nil || start.Before(time.Now()) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a future time like 2026-10-23T20:00.", m.Author.ID, args[2]))
+		return
+	}
+	queueType, ok := parseQueueType(args[3])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a queue.", m.Author.ID, args[3]))
+		return
+	}
+	capacity, _ := strconv.Atoi(args[4])
+	if required := searchQueues[queueType].RequiredPlayers; capacity < required {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: A %s event needs room for at least %d players.", m.Author.ID, queueName(queueType), required))
+		return
+	}
+
+	event, err := scheduleStore.CreateEvent(args[1], start, queueType, capacity, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not create the event.", m.Author.ID))
+		return
+	}
+
+	logModAction(s, guildID, m.Author.ID, "created event "+event.Name, 0, start.Format(eventTimeLayout))
+	s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf(pickup.Commands("%s (%s, %d players) starts %s! Sign up with \"!event signup %d\"."),
+		event.Name, queueName(queueType), capacity, start.Format("Mon Jan 2 15:04 MST"), event.ID))
+}
+
+// listEvents shows the upcoming events.
+func listEvents(s *discordgo.Session, m *discordgo.MessageCreate) {
+	events := scheduleStore.GetUpcomingEvents()
+	if len(events) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There are no upcoming events.", m.Author.ID))
+		return
+	}
+
+	msg := "Upcoming events:"
+	for _, event := range events {
+		msg += fmt.Sprintf("\n#%d %s - %s, %s, %d/%d signed up", event.ID, event.Name, event.Start.Format("Mon Jan 2 15:04 MST"),
+			queueName(event.QueueType), len(scheduleStore.GetSignups(event.ID)), event.Capacity)
+	}
+	s.ChannelMessageSend(m.ChannelID, msg)
+}
+
+// eventInfo shows who is signed up for an event and who is on its waitlist.
+func eventInfo(s *discordgo.Session, m *discordgo.MessageCreate, event *pickup.ScheduledEvent) {
+	guildID := pickup.GetGuildID(s)
+	var players, waitlist []string
+	for i, id := range scheduleStore.GetSignups(event.ID) {
+		if i < event.Capacity {
+			players = append(players, pickup.DisplayName(s, guildID, id))
+		} else {
+			waitlist = append(waitlist, pickup.DisplayName(s, guildID, id))
+		}
+	}
+
+	msg := fmt.Sprintf("%s starts %s.\nPlayers (%d/%d): %s", event.Name, event.Start.Format("Mon Jan 2 15:04 MST"), len(players), event.Capacity, strings.Join(players, ", "))
+	if len(waitlist) > 0 {
+		msg += "\nWaitlist: " + strings.Join(waitlist, ", ")
+	}
+	s.ChannelMessageSend(m.ChannelID, msg)
+}
+
+// eventSignUp signs the author up for an event. Players who sign up after it is full go on the waitlist.
+func eventSignUp(s *discordgo.Session, m *discordgo.MessageCreate, event *pickup.ScheduledEvent) {
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can sign up for events."), m.Author.ID))
+		return
+	}
+	if err := scheduleStore.SignUp(event.ID, m.Author.ID); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are already signed up for %s.", m.Author.ID, event.Name))
+		return
+	}
+
+	if len(scheduleStore.GetSignups(event.ID)) > event.Capacity {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is full, so you have been put on the waitlist.", m.Author.ID, event.Name))
+	} else {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have signed up for %s!", m.Author.ID, event.Name))
+	}
+}
+
+// startScheduler starts checking scheduled events for reminders and start times.
+func startScheduler(s *discordgo.Session) {
+	scheduleOnce.Do(func() {
+		go func() {
+			for {
+				checkEvents(s)
+				time.Sleep(scheduleCheckInterval)
+			}
+		}()
+	})
+}
+
+// checkEvents sends any reminders that are due and starts events whose time has come.
+func checkEvents(s *discordgo.Session) {
+	for _, event := range scheduleStore.GetUpcomingEvents() {
+		until := time.Until(event.Start)
+		if until <= 0 {
+			startEvent(s, event)
+			continue
+		}
+
+		// Only the latest reminder that is due is sent, so a late start does not send several at once
+		due := event.Reminders
+		for due < len(eventReminders) && until <= eventReminders[due] {
+			due++
+		}
+		if due > event.Reminders {
+			scheduleStore.SetReminders(event.ID, due)
+			remindEvent(s, event, until)
+		}
+	}
+}
+
+// remindEvent reminds the players signed up for an event that it is starting soon.
+func remindEvent(s *discordgo.Session, event *pickup.ScheduledEvent, until time.Duration) {
+	msg := fmt.Sprintf("%s starts in %s! Make sure you are online.", event.Name, until.Round(time.Minute))
+	for _, id := range scheduleStore.GetSignups(event.ID) {
+		sendDM(s, id, msg)
+	}
+	s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf(pickup.Commands("%s Sign up with \"!event signup %d\"."), msg, event.ID))
+}
+
+// startEvent forms as many rooms as the sign ups fill, in sign up order.
+// Everyone left over, including the waitlist, joins the normal queue for the event's queue type.
+func startEvent(s *discordgo.Session, event *pickup.ScheduledEvent) {
+	scheduleStore.SetStarted(event.ID)
+	guildID := pickup.GetGuildID(s)
+	q := searchQueues[event.QueueType]
+
+	var players []*pickup.Player
+	var busy, starting []string
+	for _, id := range scheduleStore.GetSignups(event.ID) {
+		if !playerStore.PlayerExists(id) || banStore.GetBan(id) != nil {
+			continue
+		}
+
+		p := registry.Load(id, playerStore)
+		if registry.State(id) == pickup.StateSearching {
+			removeFromQueues(s, guildID, p, "joined event "+event.Name)
+		}
+		if registry.Transition(id, pickup.StateIdle, pickup.StateSearching) != nil {
+			if registry.State(id) == pickup.StateReadyCheck {
+				starting = append(starting, "<@"+id+">")
+			} else {
+				busy = append(busy, "<@"+id+">")
+			}
+			continue
+		}
+		players = append(players, p)
+	}
+
+	// Rooms are filled by sign ups alone, without going through the queue
+	capacity := len(players)
+	if capacity > event.Capacity {
+		capacity = event.Capacity
+	}
+	rooms, left := q.GroupRooms(players[:capacity])
+	left = append(left, players[capacity:]...)
+	for _, room := range rooms {
+		eventLog.Log(pickup.EventEnqueue, room.ID, "event "+event.Name, playerIDs(room.PlayerList())...)
+		startRoom(s, room, event.QueueType)
+	}
+
+	var waitlist []string
+	for _, p := range left {
+		waitlist = append(waitlist, "<@"+p.ID+">")
+		if room := joinQueue(s, guildID, p, q, event.QueueType, nil); room != nil {
+			startRoom(s, room, event.QueueType)
+		}
+	}
+
+	msg := fmt.Sprintf("%s has started with %d rooms!", event.Name, len(rooms))
+	if len(waitlist) > 0 {
+		msg += fmt.Sprintf("\n%s: You are on the waitlist and have been added to the %s queue.", strings.Join(waitlist, " "), queueName(event.QueueType))
+	}
+	if len(busy) > 0 {
+		msg += fmt.Sprintf("\n%s: You could not join because you are already in a match.", strings.Join(busy, " "))
+	}
+	if len(starting) > 0 {
+		msg += fmt.Sprintf("\n%s: You could not join because a room is being set up for another match of yours.", strings.Join(starting, " "))
+	}
+	s.ChannelMessageSend(pickup.SearchChannelID, msg)
+}
+
+// playerIDs gets the IDs of a list of players.
+func playerIDs(players []*pickup.Player) []string {
+	var ids []string
+	for _, p := range players {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// tagRegex matches a valid team tag.
+var tagRegex = regexp.MustCompile(`^[A-Za-z0-9]{2,6}$`)
+
+// teamCommand handles the "!team" commands.
+func teamCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !team create|invite|join|leave|kick|disband|info"), m.Author.ID))
+		return
+	}
+
+	switch input[1] {
+	case "create":
+		createTeam(s, m, input[2:])
+	case "invite":
+		inviteToTeam(s, m, input[2:])
+	case "join":
+		joinTeam(s, m, input[2:])
+	case "leave":
+		leaveTeam(s, m)
+	case "kick":
+		kickFromTeam(s, m, input[2:])
+	case "disband":
+		disbandTeam(s, m)
+	case "info":
+		teamInfo(s, m, input[2:])
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Unknown team command \"%s\".", m.Author.ID, input[1]))
+	}
+}
+
+// createTeam creates a team with the author as captain.
+func createTeam(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !team create <tag> <name>"), m.Author.ID))
+		return
+	}
+
+	tag := args[0]
+	name := strings.Join(args[1:], " ")
+	if !tagRegex.MatchString(tag) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: A tag must be 2 to 6 letters or numbers.", m.Author.ID))
+		return
+	}
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can create a team."), m.Author.ID))
+		return
+	}
+	if team := teamStore.GetPlayerTeam(m.Author.ID); team != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are already on team %s.", m.Author.ID, team.Name))
+		return
+	}
+	if teamStore.TeamExists(name, tag) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: A team with that name or tag already exists.", m.Author.ID))
+		return
+	}
+
+	team, err := teamStore.CreateTeam(name, tag, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not create the team.", m.Author.ID))
+		return
+	}
+
+	eventLog.Log(pickup.EventTeam, 0, "created team "+team.Name, m.Author.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Team %s [%s] has been created! Invite players with \"!team invite @user\"."), m.Author.ID, team.Name, team.Tag))
+}
+
+// inviteToTeam invites a player to the captain's team.
+func inviteToTeam(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	team := captainTeam(s, m)
+	if team == nil {
+		return
+	}
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !team invite @user"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a valid player name.", m.Author.ID, args[0]))
+		return
+	}
+	if maxSize := currentConfig().MaxTeamSize; len(team.Members) >= maxSize {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Your team already has %d members.", m.Author.ID, maxSize))
+		return
+	}
+	if other := teamStore.GetPlayerTeam(id); other != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is already on team %s.", m.Author.ID, id, other.Name))
+		return
+	}
+
+	teamStore.Invite(team.ID, id)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You have been invited to %s. Type \"!team join %s\" to join."), id, team.Name, team.Tag))
+}
+
+// joinTeam adds the author to a team that invited them.
+func joinTeam(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !team join <tag>"), m.Author.ID))
+		return
+	}
+
+	team := teamStore.GetTeamByTag(args[0])
+	if team == nil || !teamStore.HasInvite(team.ID, m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have not been invited to a team with the tag %s.", m.Author.ID, args[0]))
+		return
+	}
+	if !playerStore.PlayerExists(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: You must \"!register\" before you can join a team."), m.Author.ID))
+		return
+	}
+	if other := teamStore.GetPlayerTeam(m.Author.ID); other != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are already on team %s.", m.Author.ID, other.Name))
+		return
+	}
+	if len(team.Members) >= currentConfig().MaxTeamSize {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is full.", m.Author.ID, team.Name))
+		return
+	}
+
+	if err := teamStore.AddMember(team.ID, m.Author.ID); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not join %s.", m.Author.ID, team.Name))
+		return
+	}
+
+	eventLog.Log(pickup.EventTeam, 0, "joined team "+team.Name, m.Author.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have joined %s!", m.Author.ID, team.Name))
+}
+
+// leaveTeam removes the author from their team. Captains must disband their team instead.
+func leaveTeam(s *discordgo.Session, m *discordgo.MessageCreate) {
+	team := teamStore.GetPlayerTeam(m.Author.ID)
+	if team == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You are not on a team.", m.Author.ID))
+		return
+	}
+	if team.CaptainID == m.Author.ID {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Captains cannot leave their team. Use \"!team disband\" instead."), m.Author.ID))
+		return
+	}
+
+	teamStore.RemoveMember(team.ID, m.Author.ID)
+	eventLog.Log(pickup.EventTeam, 0, "left team "+team.Name, m.Author.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: You have left %s.", m.Author.ID, team.Name))
+}
+
+// kickFromTeam removes a member from the captain's team.
+func kickFromTeam(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	team := captainTeam(s, m)
+	if team == nil {
+		return
+	}
+	if len(args) < 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !team kick @user"), m.Author.ID))
+		return
+	}
+
+	id, ok := parseMention(args[0])
+	if !ok || !team.HasMember(id) || id == team.CaptainID {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a member of your team.", m.Author.ID, args[0]))
+		return
+	}
+
+	teamStore.RemoveMember(team.ID, id)
+	eventLog.Log(pickup.EventTeam, 0, "kicked from team "+team.Name, id)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> has been removed from %s.", m.Author.ID, id, team.Name))
+}
+
+// disbandTeam deletes the captain's team.
+func disbandTeam(s *discordgo.Session, m *discordgo.MessageCreate) {
+	team := captainTeam(s, m)
+	if team == nil {
+		return
+	}
+
+	teamStore.DeleteTeam(team.ID)
+	eventLog.Log(pickup.EventTeam, 0, "disbanded team "+team.Name, team.Members...)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s has been disbanded.", m.Author.ID, team.Name))
+}
+
+// teamInfo shows a team's members and record. Without a tag, the author's team is shown.
+func teamInfo(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	var team *pickup.Team
+	if len(args) > 0 {
+		team = teamStore.GetTeamByTag(args[0])
+	} else {
+		team = teamStore.GetPlayerTeam(m.Author.ID)
+	}
+	if team == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Team not found.", m.Author.ID))
+		return
+	}
+
+	guildID := pickup.GetGuildID(s)
+	var names []string
+	for _, id := range team.Members {
+		name := pickup.DisplayName(s, guildID, id)
+		if id == team.CaptainID {
+			name += " (captain)"
+		}
+		names = append(names, name)
+	}
+
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s [%s]\nRecord: %d-%d\nRating: %d\nMembers: %s",
+		team.Name, team.Tag, team.Wins, team.Losses, team.Rating, strings.Join(names, ", ")))
+}
+
+// captainTeam gets the team the author is captain of, telling them if they are not a captain.
+func captainTeam(s *discordgo.Session, m *discordgo.MessageCreate) *pickup.Team {
+	team := teamStore.GetPlayerTeam(m.Author.ID)
+	if team == nil || team.CaptainID != m.Author.ID {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only a team captain can do that.", m.Author.ID))
+		return nil
+	}
+	return team
+}
+
+// addScrimToQueue queues the author's team for a scrim against another team.
+// If the team has more than four members, the captain mentions the three members who are playing.
+func addScrimToQueue(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	team := teamStore.GetPlayerTeam(m.Author.ID)
+	if team == nil || team.CaptainID != m.Author.ID {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Only a team captain can queue for a scrim. Create a team with \"!team create\"."), m.Author.ID))
+		return
+	}
+
+	roster := []string{m.Author.ID}
+	if len(input) > 1 {
+		for _, arg := range input[1:] {
+			id, ok := parseMention(arg)
+			if !ok || !team.HasMember(id) {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s is not a member of your team.", m.Author.ID, arg))
+				return
+			}
+			if id == m.Author.ID {
+				continue
+			}
+			if containsString(roster, id) {
+				s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> was mentioned more than once.", m.Author.ID, id))
+				return
+			}
+			roster = append(roster, id)
+		}
+	} else if len(team.Members) == pickup.ScrimTeamSize {
+		roster = team.Members
+	}
+
+	if len(roster) != pickup.ScrimTeamSize {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: A scrim needs exactly %d players. Usage: !scrim @user @user @user"), m.Author.ID, pickup.ScrimTeamSize))
+		return
+	}
+
+	for _, id := range roster {
+		if ban := banStore.GetBan(id); ban != nil {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: <@%s> is banned from matchmaking %s", m.Author.ID, id, banDescription(ban)))
+			return
+		}
+	}
+
+	queued := &pickup.QueuedTeam{Team: team}
+	for _, id := range roster {
+		queued.Players = append(queued.Players, registry.Load(id, playerStore))
+	}
+
+	if err := registry.TransitionAll(roster, pickup.StateIdle, pickup.StateSearching); err != nil {
+		if err, ok := err.(*pickup.TransitionError); ok {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: <@%s> must \"!leave\" their current queue or match before your team can scrim."), m.Author.ID, err.PlayerID))
+		}
+		return
+	}
+
+	eventLog.Log(pickup.EventEnqueue, 0, "scrim as "+team.Name, roster...)
+	room := scrimQueue.Enqueue(queued)
+	if room == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Team %s has been added to the scrim queue.", m.Author.ID, team.Name))
+	} else {
+		startRoom(s, room, pickup.Scrim)
+	}
+	guildID := pickup.GetGuildID(s)
+	for _, p := range queued.Players {
+		checkPresence(s, guildID, p)
+	}
+}
+
+// reportResult records the result of a game in a team room or a pick/ban set. Only captains can report results.
+func reportResult(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if len(input) < 2 || (input[1] != "win" && input[1] != "loss") {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !result win|loss"), m.Author.ID))
+		return
+	}
+
+	p, ok := registry.Get(m.Author.ID)
+	if !ok {
+		return
+	}
+	room := findRoomByChannel(m.ChannelID)
+	if room == nil {
+		return
+	}
+	won := input[1] == "win"
+	pickBan := room.PickBan()
+
+	if room.Teams == nil {
+		// Rooms without teams can only report results for a pick/ban set
+		if pickBan == nil || pickBan.Side(p.ID) < 0 {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only a captain can report results.", m.Author.ID))
+			return
+		}
+		winnerID := p.ID
+		if !won {
+			winnerID = pickBan.Captains[1-pickBan.Side(p.ID)]
+		}
+		reportPickBanGame(s, m, room, pickBan, winnerID)
+		return
+	}
+
+	team := room.PlayerTeam(p)
+	if team == nil || team.Team.CaptainID != p.ID {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only a team captain can report results.", m.Author.ID))
+		return
+	}
+
+	winner, loser := team, opponentTeam(room, team)
+	if !won {
+		winner, loser = loser, winner
+	}
+	// A pick/ban set accepts one result per game, and a room without one accepts a single result
+	if pickBan != nil && !reportPickBanGame(s, m, room, pickBan, winner.Team.CaptainID) {
+		return
+	}
+	if pickBan == nil && !room.ReportResult() {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: The result of this match has already been reported.", m.Author.ID))
+		return
+	}
+	teamStore.RecordMatch(winner.Team.ID, loser.Team.ID)
+
+	eventLog.Log(pickup.EventResult, room.ID, fmt.Sprintf("%s beat %s", winner.Team.Name, loser.Team.Name), m.Author.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Recorded a win for %s and a loss for %s.", winner.Team.Name, loser.Team.Name))
+
+	// A tournament match with a pick/ban set is decided by the set, not by a single game
+	if pickBan != nil && pickBan.State() != pickup.PickBanFinished {
+		return
+	}
+	if t := currentTournament(); t != nil {
+		if match := t.MatchForRoom(room.ID); match != nil {
+			if err := advanceBracket(s, t, match.ID, winner.Team); err != nil {
+				room.Logger().Error("Error occurred while advancing the bracket", "match", match.ID, "err", err)
+			}
+		}
+	}
+}
+
+// opponentTeam gets the other team in a room with two teams.
+func opponentTeam(room *pickup.Room, team *pickup.QueuedTeam) *pickup.QueuedTeam {
+	for _, t := range room.Teams {
+		if t != team {
+			return t
+		}
+	}
+	return nil
+}
+
+// containsString checks if a list of strings contains a string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
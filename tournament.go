@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/krankdud/squidup/pickup"
+)
+
+// tournament is the current tournament, or nil if there is none.
+var tournament *pickup.Tournament
+var tournamentMutex sync.Mutex
+
+// currentTournament gets the current tournament.
+func currentTournament() *pickup.Tournament {
+	tournamentMutex.Lock()
+	defer tournamentMutex.Unlock()
+
+	return tournament
+}
+
+// tournamentCommand handles the "!tournament" commands.
+func tournamentCommand(s *discordgo.Session, m *discordgo.MessageCreate, input []string) {
+	if len(input) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !tournament create|signup|withdraw|start|report|rooms|end"), m.Author.ID))
+		return
+	}
+
+	switch input[1] {
+	case "signup":
+		tournamentSignUp(s, m)
+		return
+	case "withdraw":
+		tournamentWithdraw(s, m)
+		return
+	}
+
+	guildID := pickup.GetGuildID(s)
+	if !pickup.MemberHasRole(s, guildID, m.Author.ID, moderatorRole) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Only moderators can run tournaments.", m.Author.ID))
+		return
+	}
+
+	switch input[1] {
+	case "create":
+		createTournament(s, m, input[2:])
+	case "start":
+		startTournament(s, m)
+	case "report":
+		tournamentReport(s, m, input[2:])
+	case "rooms":
+		createMatchRooms(s)
+	case "end":
+		endTournament(s, m)
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Unknown tournament command \"%s\".", m.Author.ID, input[1]))
+	}
+}
+
+// createTournament opens sign ups for a new tournament.
+func createTournament(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) < 2 || (args[0] != "single" && args[0] != "double") {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !tournament create single|double <name>"), m.Author.ID))
+		return
+	}
+
+	format := pickup.SingleElimination
+	if args[0] == "double" {
+		format = pickup.DoubleElimination
+	}
+	name := strings.Join(args[1:], " ")
+
+	tournamentMutex.Lock()
+	if tournament != nil && tournament.Champion() == nil {
+		tournamentMutex.Unlock()
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: %s is still running. Use \"!tournament end\" first."), m.Author.ID, tournament.Name))
+		return
+	}
+	tournament = pickup.NewTournament(name, format)
+	tournamentMutex.Unlock()
+
+	logModAction(s, pickup.GetGuildID(s), m.Author.ID, "created tournament "+name, 0, "")
+	s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf(pickup.Commands("Sign ups for %s are open! Team captains can type \"!tournament signup\" to enter."), name))
+}
+
+// tournamentSignUp enters the author's team into the tournament.
+func tournamentSignUp(s *discordgo.Session, m *discordgo.MessageCreate) {
+	t := currentTournament()
+	if t == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no tournament to sign up for.", m.Author.ID))
+		return
+	}
+	team := captainTeam(s, m)
+	if team == nil {
+		return
+	}
+	if len(team.Members) < pickup.ScrimTeamSize {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Your team needs at least %d members to enter.", m.Author.ID, pickup.ScrimTeamSize))
+		return
+	}
+
+	if err := t.SignUp(team); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not sign up: %s.", m.Author.ID, err))
+		return
+	}
+
+	eventLog.Log(pickup.EventTeam, 0, fmt.Sprintf("signed %s up for %s", team.Name, t.Name), m.Author.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s has signed up for %s!", m.Author.ID, team.Name, t.Name))
+}
+
+// tournamentWithdraw removes the author's team from the tournament before it starts.
+func tournamentWithdraw(s *discordgo.Session, m *discordgo.MessageCreate) {
+	t := currentTournament()
+	if t == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no tournament to withdraw from.", m.Author.ID))
+		return
+	}
+	team := captainTeam(s, m)
+	if team == nil {
+		return
+	}
+
+	if err := t.Withdraw(team.ID); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not withdraw: %s.", m.Author.ID, err))
+		return
+	}
+
+	eventLog.Log(pickup.EventTeam, 0, fmt.Sprintf("withdrew %s from %s", team.Name, t.Name), m.Author.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s has withdrawn from %s.", m.Author.ID, team.Name, t.Name))
+}
+
+// startTournament closes sign ups, generates the bracket and creates rooms for the first matches.
+func startTournament(s *discordgo.Session, m *discordgo.MessageCreate) {
+	t := currentTournament()
+	if t == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no tournament to start.", m.Author.ID))
+		return
+	}
+
+	if err := t.Start(); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: Could not start the tournament: %s.", m.Author.ID, err))
+		return
+	}
+
+	logModAction(s, pickup.GetGuildID(s), m.Author.ID, "started tournament "+t.Name, 0, "")
+	s.ChannelMessageSend(pickup.SearchChannelID, t.Render())
+	createMatchRooms(s)
+}
+
+// tournamentReport records the winner of a match for a moderator, for matches whose room was closed or never created.
+func tournamentReport(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	t := currentTournament()
+	if t == nil || len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !tournament report <match> <winning tag>"), m.Author.ID))
+		return
+	}
+
+	matchID, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	team := teamStore.GetTeamByTag(args[1])
+	if err != nil || team == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf(pickup.Commands("<@%s>: Usage: !tournament report <match> <winning tag>"), m.Author.ID))
+		return
+	}
+
+	if err := advanceBracket(s, t, matchID, team); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s.", m.Author.ID, err))
+		return
+	}
+	logModAction(s, pickup.GetGuildID(s), m.Author.ID, "reported tournament result", 0, fmt.Sprintf("match %d won by %s", matchID, team.Name))
+}
+
+// endTournament removes the current tournament. Rooms that are still open are left for moderators to close.
+func endTournament(s *discordgo.Session, m *discordgo.MessageCreate) {
+	tournamentMutex.Lock()
+	t := tournament
+	tournament = nil
+	tournamentMutex.Unlock()
+
+	if t == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no tournament to end.", m.Author.ID))
+		return
+	}
+
+	logModAction(s, pickup.GetGuildID(s), m.Author.ID, "ended tournament "+t.Name, 0, "")
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: %s has ended.", m.Author.ID, t.Name))
+}
+
+// showBracket shows the current state of the tournament.
+func showBracket(s *discordgo.Session, m *discordgo.MessageCreate) {
+	t := currentTournament()
+	if t == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: There is no tournament running.", m.Author.ID))
+		return
+	}
+	s.ChannelMessageSend(m.ChannelID, t.Render())
+}
+
+// advanceBracket records the winner of a tournament match and creates rooms for the matches that are now ready.
+// The room the match was played in is closed first, so that its players are free to join their next match.
+func advanceBracket(s *discordgo.Session, t *pickup.Tournament, matchID int, winner *pickup.Team) error {
+	opened, err := t.Report(matchID, winner.ID)
+	if err != nil {
+		return err
+	}
+
+	eventLog.Log(pickup.EventResult, 0, fmt.Sprintf("%s won match %d of %s", winner.Name, matchID, t.Name))
+	if room := findRoom(t.MatchRoom(matchID)); room != nil {
+		finishRoom(s, pickup.GetGuildID(s), room, "The match is over.", fmt.Sprintf("tournament match %d finished", matchID))
+	}
+	if champion := t.Champion(); champion != nil {
+		s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf("%s has won %s! Congratulations!", champion.Name, t.Name))
+		return nil
+	}
+	if len(opened) > 0 {
+		createMatchRooms(s)
+	}
+	return nil
+}
+
+// createMatchRooms creates a room for every playable tournament match that does not have one.
+// Each team plays with its captain and the first members who are free, and members who are searching are taken out of their queues.
+// A match is left without a room if either team cannot field a full roster.
+func createMatchRooms(s *discordgo.Session) {
+	t := currentTournament()
+	if t == nil {
+		return
+	}
+
+	guildID := pickup.GetGuildID(s)
+	for _, match := range t.Playable() {
+		if !t.ClaimMatch(match.ID) {
+			continue
+		}
+
+		var rosters [2][]*pickup.Player
+		var short []string
+		for i, team := range match.Teams {
+			rosters[i] = matchRoster(team)
+			if len(rosters[i]) < pickup.ScrimTeamSize {
+				short = append(short, team.Name)
+			}
+		}
+		if len(short) > 0 {
+			t.Unclaim(match.ID)
+			s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf(pickup.Commands("Match %d of %s is waiting for %s to have %d players free. A moderator can retry with \"!tournament rooms\"."), match.ID, t.Name, strings.Join(short, " and "), pickup.ScrimTeamSize))
+			continue
+		}
+
+		var teams []*pickup.QueuedTeam
+		var ids []string
+		var missing []string
+		for i, team := range match.Teams {
+			queued := &pickup.QueuedTeam{Team: team}
+			for _, p := range rosters[i] {
+				if registry.State(p.ID) == pickup.StateSearching {
+					removeFromQueues(s, guildID, p, "joined a tournament match")
+				}
+				if err := registry.Transition(p.ID, pickup.StateIdle, pickup.StateInMatch); err != nil {
+					missing = append(missing, "<@"+p.ID+">")
+					continue
+				}
+				queued.Players = append(queued.Players, p)
+				ids = append(ids, p.ID)
+			}
+			teams = append(teams, queued)
+		}
+
+		room := pickup.NewTeamRoom(teams...)
+		if err := room.SetupRoom(s, pickup.Scrim); err != nil {
+			room.Logger().Error("Error occurred while setting up tournament room", "match", match.ID, "players", ids, "err", err)
+			for _, id := range ids {
+				registry.Release(id)
+			}
+			t.Unclaim(match.ID)
+			eventLog.Log(pickup.EventRoomFailed, room.ID, fmt.Sprintf("tournament match %d", match.ID), ids...)
+			s.ChannelMessageSend(pickup.SearchChannelID, fmt.Sprintf(pickup.Commands("The room for match %d of %s could not be created. A moderator can retry with \"!tournament rooms\"."), match.ID, t.Name))
+			continue
+		}
+
+		t.SetRoom(match.ID, room.ID)
+		addRoom(room)
+		eventLog.Log(pickup.EventRoomCreated, room.ID, fmt.Sprintf("tournament match %d", match.ID), ids...)
+		msg := fmt.Sprintf(pickup.Commands("Match %d of %s: %s vs %s. Captains report the winner with \"!result win|loss\"."), match.ID, t.Name, match.Teams[0].Name, match.Teams[1].Name)
+		if len(missing) > 0 {
+			msg += fmt.Sprintf("\n%s could not join because they are already in a match.", strings.Join(missing, " "))
+		}
+		s.ChannelMessageSend(room.TextChannel, msg)
+		notifyMatchFound(s, room)
+	}
+}
+
+// matchRoster picks the players a team plays a tournament match with: its captain and then the first members who are not in a match.
+// Returns nil if the captain is busy, and fewer than pickup.ScrimTeamSize players if not enough members are free.
+func matchRoster(team *pickup.Team) []*pickup.Player {
+	free := func(id string) bool {
+		state := registry.State(id)
+		return state == pickup.StateIdle || state == pickup.StateSearching
+	}
+	if !free(team.CaptainID) {
+		return nil
+	}
+
+	roster := []*pickup.Player{registry.Load(team.CaptainID, playerStore)}
+	for _, id := range team.Members {
+		if len(roster) == pickup.ScrimTeamSize {
+			break
+		}
+		if id != team.CaptainID && free(id) {
+			roster = append(roster, registry.Load(id, playerStore))
+		}
+	}
+	return roster
+}